@@ -1,13 +1,12 @@
 package api
 
 import (
+	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
-	"sync"
 	"time"
-	"wakelan/backend/comm"
 	"wakelan/backend/db"
 
 	"github.com/gin-gonic/gin"
@@ -37,15 +36,54 @@ func (a *Web) SetWakeAPI(r *gin.Engine) {
 	group.GET("/getip", api.getGlobalIP)
 	group.GET("/getinterfaces", api.getInterfaces)
 	group.GET("/probenetwork", api.probeNetwork)
+	group.GET("/scanstatus", api.scanStatus)
+	group.GET("/cancelscan", api.cancelScan)
+	group.GET("/scanrecords", api.getScanRecords)
+	group.GET("/delscanrecord", api.delScanRecord)
 	group.GET("/delnetworklist", api.delNetworklist)
 	group.GET("/getnetworklist", api.getNetworklist)
+	group.GET("/listdevices", api.listDevices)
+	group.POST("/setdeviceinfo", api.setDeviceInfo)
 	group.GET("/wakeLan", api.wakeLan)
+	group.GET("/setwakemode", api.setWakeMode)
+	group.GET("/setsecureon", api.setSecureOn)
+	group.GET("/wakejob", api.wakeJob)
+	group.GET("/wakejobstatus", api.wakeJobStatus)
+	group.POST("/setpower", api.setPower)
+	group.GET("/power", api.powerPC)
+	group.GET("/getgroups", api.getGroups)
+	group.POST("/savegroup", api.saveGroup)
+	group.GET("/delgroup", api.delGroup)
+	group.GET("/wakegroup", api.wakeGroup)
+	group.GET("/wakegroupstatus", api.wakeGroupStatus)
+	group.GET("/genrelaykey", api.genRelayKey)
+	group.GET("/presence", api.getPresence)
+	group.GET("/presencetimeline", api.getPresenceTimeline)
+	group.GET("/probeservice", api.probeService)
+	group.GET("/getservices", api.getServices)
 	group.GET("/operstar", api.operStar)
+	group.GET("/settrusted", api.setTrusted)
 	group.GET("/opencard", api.openCard)
+	group.GET("/closecard", api.closeCard)
+	group.GET("/getopencards", api.getOpenCards)
 	group.GET("/getselectnetcard", api.getSelectNetCard)
 	group.GET("/pingpc", api.pingPC)
 	group.GET("/editpcinfo", api.editPCInfo)
 	group.GET("/addnetworklist", api.addNetworklist)
+	group.GET("/exportinventory", api.exportInventory)
+	group.POST("/importinventory", api.importInventory)
+}
+
+func (a *Web) SetScheduleAPI(r *gin.Engine) {
+	api := &Schedule{}
+	api.Init()
+
+	group := r.Group("/api/wake/schedule")
+	group.GET("/getjobs", api.GetJobs)
+	group.POST("/savejob", api.SaveJob)
+	group.GET("/deljob", api.DelJob)
+	group.GET("/enablejob", api.EnableJob)
+	group.GET("/runjob", api.RunJob)
 }
 
 func (a *Web) SetRemoteAPI(r *gin.Engine) {
@@ -66,7 +104,25 @@ func (a *Web) SetSystemAPI(r *gin.Engine) {
 	group.GET("/log", api.GetLog)
 	group.GET("/configinfo", api.GetConfigInfo)
 	group.GET("/setconfig", api.SetConfig)
-	group.GET("/genpwd", api.GenDynamicPassword)
+	group.POST("/uploadmanuf", api.UploadManuf)
+	group.GET("/rotatetoken", api.RotateToken)
+}
+
+func (a *Web) SetUserAPI(r *gin.Engine) {
+	api := &UserApi{}
+
+	group := r.Group("/api/user")
+	group.GET("/me", api.me)
+	group.GET("/list", api.list)
+	group.POST("/save", api.save)
+	group.POST("/del", api.del)
+	group.POST("/enroll", api.enroll)
+	group.POST("/confirm", api.confirm)
+	group.POST("/recoverycodes", api.recoveryCodes)
+	group.POST("/logout", api.logout)
+	group.GET("/sessions", api.sessions)
+	group.POST("/revokesession", api.revokeSession)
+	group.POST("/revokeallsessions", api.revokeAllSessions)
 }
 
 func (a *Web) SetFileAPI(r *gin.Engine) {
@@ -118,58 +174,81 @@ func (a *Web) SetDockerClientApi(r *gin.Engine) {
 
 ///////////////////////////////////////////////////////////////////
 
-var tokenManagerObj *comm.TokenManager
-var tokenManagerOnce sync.Once
-
-func TokenManager() *comm.TokenManager {
-	tokenManagerOnce.Do(func() {
-		obj := &comm.TokenManager{}
-		cfg := db.DBOperObj().GetConfig()
-		obj.Init([]byte(cfg.Secret), []byte("9C1A64F21B7B6A82"))
-		tokenManagerObj = obj
-	})
-
-	return tokenManagerObj
-}
-
-var fileSharedObj *comm.TokenManager
-var fileSharedOnce sync.Once
-
-func FileSharedMG() *comm.TokenManager {
-	fileSharedOnce.Do(func() {
-		obj := &comm.TokenManager{}
-		key := comm.GenRandKey()
-		obj.Init([]byte(key), []byte(key)[0:16])
-		fileSharedObj = obj
-	})
-
-	return fileSharedObj
-}
-
-///////////////////////////////////////////////////////////////////
-
 func (a *Web) Login(r *gin.Engine) {
 	api := r.Group("/api")
 	api.GET("/login", func(c *gin.Context) {
 		code := c.Query("code")
+		name := c.DefaultQuery("user", db.DefaultUser)
+		ip := c.ClientIP()
 
-		info := db.DBOperObj().GetConfig()
-		if len(info.Secret) != 0 {
-			valid := totp.Validate(code, info.Secret)
-			if !valid {
-				db.DBLog("登录", "登录失败, key:%s", code)
-				c.JSON(200, gin.H{
-					"err":   "密钥无效",
-					"infos": "",
-				})
-				return
+		guard := LoginGuardObj()
+		ok, wait := guard.Check(ip, name)
+		if !ok {
+			db.DBUserLog(name, "登录", "登录被限制, IP:%s", ip)
+			c.JSON(200, gin.H{
+				"err":   fmt.Sprintf("尝试次数过多，请 %d 秒后重试", int(wait.Seconds())+1),
+				"infos": "",
+			})
+			return
+		}
+
+		user, err := db.GetUser(name)
+		if err != nil || user.Disabled {
+			fails := guard.Fail(ip, name)
+			db.DBUserLog(name, "登录", "登录失败, 用户不存在或已禁用, IP:%s, 连续失败:%d", ip, fails)
+			c.JSON(200, gin.H{
+				"err":   "密钥无效",
+				"infos": "",
+			})
+			return
+		}
+
+		valid := false
+		switch {
+		case len(user.Secret) != 0:
+			valid = totp.Validate(code, user.Secret)
+			if !valid && db.UseRecoveryCode(user.Name, code) {
+				valid = true
+				db.DBUserLog(name, "登录", "使用恢复码登录, IP:%s, 剩余恢复码:%d", ip, db.RecoveryCodeLeft(user.Name))
+			}
+		case len(user.PendingSecret) != 0:
+			//待绑定的用户使用管理员下发的动态密码登录，验证通过后启用
+			valid = totp.Validate(code, user.PendingSecret)
+			if valid {
+				err = db.ActivateSecret(db.DBOperObj().GetDB(), user)
+				valid = err == nil
+				db.DBUserLog(name, "登录", "首次登录启用动态密码, IP:%s", ip)
 			}
+		default:
+			//只有初始管理员在未设置动态密码时免验证登录
+			valid = user.IsBootstrap()
 		}
 
-		minute := 7 * 24 * 60
-		token, err := TokenManager().GenToken(minute)
+		if !valid {
+			fails := guard.Fail(ip, name)
+			db.DBUserLog(name, "登录", "登录失败, 密钥错误, IP:%s, 连续失败:%d", ip, fails)
+			c.JSON(200, gin.H{
+				"err":   "密钥无效",
+				"infos": "",
+			})
+			return
+		}
+
+		guard.Success(ip, name)
+
+		session, err := db.CreateSession(user.Name, ip, c.Request.UserAgent())
 		if err != nil {
-			db.DBLog("登录", "登录失败, key:%s, err:%s", code, err.Error())
+			db.DBUserLog(name, "登录", "登录失败, IP:%s, err:%s", ip, err.Error())
+			c.JSON(200, gin.H{
+				"err":   "会话创建失败，err:" + err.Error(),
+				"infos": "",
+			})
+			return
+		}
+
+		token, err := TokenManager().GenToken(user.Name, session.ID, db.SessionExpire)
+		if err != nil {
+			db.DBUserLog(name, "登录", "登录失败, IP:%s, err:%s", ip, err.Error())
 			c.JSON(200, gin.H{
 				"err":   "Token生成失败，err:" + err.Error(),
 				"infos": "",
@@ -177,20 +256,23 @@ func (a *Web) Login(r *gin.Engine) {
 			return
 		}
 
-		db.DBLog("登录", "登录成功, key:%s, token:%s", code, token)
+		db.DBUserLog(name, "登录", "登录成功, IP:%s", ip)
 
-		expiration := time.Now().Add(time.Duration(minute) * time.Minute)
+		expiration := time.Now().Add(db.SessionExpire)
 		cookie := http.Cookie{
-			Name:    "token",
-			Value:   token,
-			Expires: expiration,
+			Name:     "token",
+			Value:    token,
+			Path:     "/api",
+			Expires:  expiration,
+			HttpOnly: true,
+			SameSite: http.SameSiteStrictMode,
 		}
 
 		http.SetCookie(c.Writer, &cookie)
 
 		c.JSON(200, gin.H{
 			"err":   "",
-			"infos": len(info.Secret),
+			"infos": len(user.Secret),
 		})
 	})
 }
@@ -277,21 +359,59 @@ func (a *Web) CheckToken() gin.HandlerFunc {
 			return
 		}
 
-		if TokenManager().VerifyToken(token) {
-			c.Next()
-		} else {
+		info, ok := TokenManager().ParseToken(token)
+		if !ok {
+			c.JSON(200, gin.H{
+				"err":   "token 无效",
+				"infos": "",
+			})
+			c.Abort()
+			return
+		}
+
+		session, err := db.CheckSession(info.Session, c.ClientIP())
+		if err != nil || session.User != info.Subject {
+			c.JSON(200, gin.H{
+				"err":   "token 无效",
+				"infos": "",
+			})
+			c.Abort()
+			return
+		}
+
+		user, err := db.GetUser(info.Subject)
+		if err != nil || user.Disabled {
 			c.JSON(200, gin.H{
 				"err":   "token 无效",
 				"infos": "",
 			})
 			c.Abort()
+			return
 		}
+
+		c.Set("user", user)
+		c.Set("session", session)
+
+		if !db.RoleAllow(user.Role, requiredRole(c.FullPath())) {
+			db.DBUserLog(user.Name, "权限", "拒绝访问：%s", c.FullPath())
+			c.JSON(200, gin.H{
+				"err":   "权限不足",
+				"infos": "",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
 	}
 }
 
 func (a *Web) Init(port string) {
 	r := gin.Default()
 
+	//不信任代理转发的客户端地址，避免伪造X-Forwarded-For绕过登录限制
+	r.SetTrustedProxies(nil)
+
 	//允许跨域
 	r.Use(CORSMiddleware())
 
@@ -311,6 +431,9 @@ func (a *Web) Init(port string) {
 	//设置唤醒接口
 	a.SetWakeAPI(r)
 
+	//设置定时任务接口
+	a.SetScheduleAPI(r)
+
 	//设置远程接口
 	a.SetRemoteAPI(r)
 
@@ -323,6 +446,9 @@ func (a *Web) Init(port string) {
 	//设置容器接口
 	a.SetDockerClientApi(r)
 
+	//设置用户接口
+	a.SetUserAPI(r)
+
 	// 启动服务
 	r.Run(port)
 }
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"strings"
+	"wakelan/backend/db"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 各接口分组需要的角色，未列出的分组只允许管理员
+var groupRoles = map[string]string{
+	"/api/public":        db.RoleViewer,
+	"/api/wake":          db.RoleOperator,
+	"/api/wake/schedule": db.RoleOperator,
+	"/api/remote":        db.RoleOperator,
+	"/api/system":        db.RoleAdmin,
+	"/api/file":          db.RoleOperator,
+	"/api/docker":        db.RoleAdmin,
+	"/api/user":          db.RoleAdmin,
+}
+
+// 与分组要求不同的接口
+var routeRoles = map[string]string{
+	//只读
+	"/api/wake/getip":             db.RoleViewer,
+	"/api/wake/getinterfaces":     db.RoleViewer,
+	"/api/wake/scanstatus":        db.RoleViewer,
+	"/api/wake/scanrecords":       db.RoleViewer,
+	"/api/wake/getnetworklist":    db.RoleViewer,
+	"/api/wake/listdevices":       db.RoleViewer,
+	"/api/wake/wakejobstatus":     db.RoleViewer,
+	"/api/wake/getgroups":         db.RoleViewer,
+	"/api/wake/wakegroupstatus":   db.RoleViewer,
+	"/api/wake/presence":          db.RoleViewer,
+	"/api/wake/presencetimeline":  db.RoleViewer,
+	"/api/wake/getservices":       db.RoleViewer,
+	"/api/wake/getopencards":      db.RoleViewer,
+	"/api/wake/getselectnetcard":  db.RoleViewer,
+	"/api/wake/pingpc":            db.RoleViewer,
+	"/api/wake/schedule/getjobs":  db.RoleViewer,
+	"/api/user/me":                db.RoleViewer,
+	"/api/user/logout":            db.RoleViewer,
+	"/api/user/sessions":          db.RoleViewer,
+	"/api/user/revokesession":     db.RoleViewer,
+	"/api/user/revokeallsessions": db.RoleViewer,
+	"/api/user/enroll":            db.RoleViewer,
+	"/api/user/confirm":           db.RoleViewer,
+	"/api/user/recoverycodes":     db.RoleViewer,
+	"/api/system/logsize":         db.RoleOperator,
+	"/api/system/log":             db.RoleOperator,
+	"/api/docker/getImages":       db.RoleOperator,
+	"/api/docker/getContainers":   db.RoleOperator,
+	"/api/docker/getImageDetails": db.RoleOperator,
+	"/api/docker/getBackupInfos":  db.RoleOperator,
+
+	//唤醒
+	"/api/wake/wakeLan":   db.RoleWake,
+	"/api/wake/wakejob":   db.RoleWake,
+	"/api/wake/wakegroup": db.RoleWake,
+}
+
+// 接口需要的角色
+func requiredRole(path string) string {
+	if role, ok := routeRoles[path]; ok {
+		return role
+	}
+
+	//取最长匹配的分组
+	match := ""
+	role := db.RoleAdmin
+	for group, v := range groupRoles {
+		if len(group) > len(match) && (path == group || strings.HasPrefix(path, group+"/")) {
+			match = group
+			role = v
+		}
+	}
+
+	return role
+}
+
+// 当前登录的用户，文件共享等无用户的请求返回nil
+func currentUser(c *gin.Context) *db.User {
+	v, ok := c.Get("user")
+	if !ok {
+		return nil
+	}
+
+	user, _ := v.(*db.User)
+	return user
+}
+
+// 当前用户是否为管理员
+func isAdmin(c *gin.Context) bool {
+	return hasRole(c, db.RoleAdmin)
+}
+
+// 当前用户是否具有指定角色的权限
+func hasRole(c *gin.Context, role string) bool {
+	user := currentUser(c)
+	return user != nil && db.RoleAllow(user.Role, role)
+}
+
+// 当前请求的会话
+func currentSession(c *gin.Context) *db.Session {
+	v, ok := c.Get("session")
+	if !ok {
+		return nil
+	}
+
+	session, _ := v.(*db.Session)
+	return session
+}
+
+func currentSessionID(c *gin.Context) string {
+	session := currentSession(c)
+	if session == nil {
+		return ""
+	}
+
+	return session.ID
+}
+
+func currentUserName(c *gin.Context) string {
+	user := currentUser(c)
+	if user == nil {
+		return ""
+	}
+
+	return user.Name
+}
+
+// 记录当前用户的操作
+func auditLog(c *gin.Context, cmd string, format string, a ...any) {
+	db.DBUserLog(currentUserName(c), cmd, format, a...)
+}
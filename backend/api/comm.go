@@ -9,7 +9,6 @@ import (
 	"path"
 	"strconv"
 	"strings"
-	"wakelan/backend/db"
 
 	"github.com/gin-gonic/gin"
 )
@@ -50,7 +49,7 @@ func Download(filePath string, c *gin.Context) {
 
 	rangeHeader := c.GetHeader("Range")
 	if len(rangeHeader) == 0 {
-		db.DBLog("文件传输", "下载文件:%s", fName)
+		auditLog(c, "文件传输", "下载文件:%s", fName)
 		io.Copy(c.Writer, file)
 		return
 	}
@@ -66,7 +65,7 @@ func Download(filePath string, c *gin.Context) {
 
 	//判断是否为断点下载的开始
 	if strings.Contains(rangeSplits[0], "0-0") {
-		db.DBLog("文件传输", "下载文件:%s", fName)
+		auditLog(c, "文件传输", "下载文件:%s", fName)
 	}
 
 	for _, rangeSplit := range rangeSplits {
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"wakelan/backend/db"
+
+	"github.com/gin-gonic/gin"
+)
+
+const deviceMaxPageSize = 500
+
+// 查询机器列表，支持搜索、过滤、排序和分页
+func (w *WakeApi) listDevices(c *gin.Context) {
+	q := db.DeviceQuery{
+		Keyword:    strings.TrimSpace(c.Query("q")),
+		DeviceType: c.Query("type"),
+		Tag:        c.Query("tag"),
+		Iface:      c.Query("iface"),
+		Star:       c.Query("star"),
+		Sort:       c.DefaultQuery("sort", "ip"),
+		Desc:       c.Query("order") == "desc",
+		StarFirst:  c.DefaultQuery("starfirst", "1") == "1",
+	}
+
+	if !db.IsDeviceSort(q.Sort) {
+		c.JSON(200, gin.H{
+			"err": "排序字段错误",
+		})
+		return
+	}
+
+	var err error
+	q.Page, err = strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || q.Page < 1 {
+		c.JSON(200, gin.H{
+			"err": "参数错误",
+		})
+		return
+	}
+
+	q.Size, err = strconv.Atoi(c.DefaultQuery("size", "50"))
+	if err != nil || q.Size < 1 {
+		c.JSON(200, gin.H{
+			"err": "参数错误",
+		})
+		return
+	}
+
+	if q.Size > deviceMaxPageSize {
+		q.Size = deviceMaxPageSize
+	}
+
+	saveDiscovered()
+
+	infos, total, err := db.ListDevices(q)
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"err": "",
+		"infos": gin.H{
+			"total": total,
+			"page":  q.Page,
+			"size":  q.Size,
+			"items": infos,
+		},
+	})
+}
+
+// 设置机器的自定义信息：名称、类型、厂商、标签和备注
+func (w *WakeApi) setDeviceInfo(c *gin.Context) {
+	data, _ := c.GetRawData()
+
+	info := &db.AttachInfo{}
+	err := json.Unmarshal(data, info)
+	if err != nil || len(info.Mac) == 0 {
+		c.JSON(200, gin.H{
+			"err": "参数错误",
+		})
+		return
+	}
+
+	//统一标签格式：去除空白和重复
+	tags := []string{}
+	exists := map[string]bool{}
+	for _, tag := range strings.Split(info.Tags, ",") {
+		tag = strings.TrimSpace(tag)
+		if len(tag) != 0 && !exists[tag] {
+			exists[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+
+	info.Tags = strings.Join(tags, ",")
+	info.DisplayName = strings.TrimSpace(info.DisplayName)
+	info.DeviceType = strings.TrimSpace(info.DeviceType)
+	info.VendorOverride = strings.TrimSpace(info.VendorOverride)
+
+	fields := map[string]interface{}{
+		"display_name":    info.DisplayName,
+		"device_type":     info.DeviceType,
+		"vendor_override": info.VendorOverride,
+		"tags":            info.Tags,
+		"notes":           info.Notes,
+	}
+
+	dbObj := db.DBOperObj().GetDB()
+
+	result := dbObj.Model(&db.AttachInfo{Mac: info.Mac}).Updates(fields)
+	if result.Error == nil && result.RowsAffected == 0 {
+		result = dbObj.Select("mac", "display_name", "device_type", "vendor_override", "tags", "notes").Create(info)
+	}
+
+	if result.Error != nil {
+		c.JSON(200, gin.H{
+			"err": result.Error.Error(),
+		})
+		return
+	}
+
+	auditLog(c, "编辑机器信息", "Mac：%s，名称：%s，类型：%s，标签：%s", info.Mac, info.DisplayName, info.DeviceType, info.Tags)
+
+	c.JSON(200, gin.H{
+		"err": "",
+	})
+}
@@ -189,7 +189,7 @@ func (f *FileTransfer) Upload(c *gin.Context) {
 	dbObj := db.DBOperObj().GetDB()
 
 	if meta.Index == 0 {
-		db.DBLog("文件传输", "上传文件:%s，MD5:%s", meta.Name, meta.MD5)
+		auditLog(c, "文件传输", "上传文件:%s，MD5:%s", meta.Name, meta.MD5)
 	}
 
 	if meta.Index != meta.Size {
@@ -270,7 +270,7 @@ func (f *FileTransfer) Download(c *gin.Context) {
 }
 
 func (f *FileTransfer) GenKey(c *gin.Context) {
-	key, err := FileSharedMG().GenToken(int(6 * time.Hour))
+	key, err := FileSharedMG().GenToken("", "", shareTokenExpire)
 	if err != nil {
 		c.JSON(200, gin.H{
 			"err": err.Error(),
@@ -339,7 +339,7 @@ func (f *FileTransfer) AddMessage(c *gin.Context) {
 		return
 	}
 
-	db.DBLog("消息", "%s", msg.Msg)
+	auditLog(c, "消息", "%s", msg.Msg)
 
 	dbObj := db.DBOperObj().GetDB()
 	res := dbObj.Save(&msg)
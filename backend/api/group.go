@@ -0,0 +1,193 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"wakelan/backend/db"
+	"wakelan/backend/network"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 获取分组
+func (w *WakeApi) getGroups(c *gin.Context) {
+	groups := []db.DeviceGroup{}
+	dbObj := db.DBOperObj().GetDB()
+
+	result := dbObj.Preload("Members", func(db *gorm.DB) *gorm.DB {
+		return db.Order("sort_order, mac")
+	}).Order("name").Find(&groups)
+
+	if result.Error != nil {
+		c.JSON(200, gin.H{
+			"err": result.Error.Error(),
+		})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"err":   "",
+		"infos": groups,
+	})
+}
+
+// 添加或修改分组，ID为0时添加，成员整体替换
+func (w *WakeApi) saveGroup(c *gin.Context) {
+	data, _ := c.GetRawData()
+
+	group := &db.DeviceGroup{}
+	err := json.Unmarshal(data, group)
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": "参数错误",
+		})
+		return
+	}
+
+	if len(group.Name) == 0 {
+		c.JSON(200, gin.H{
+			"err": "分组名称不能为空",
+		})
+		return
+	}
+
+	if group.Delay < 0 {
+		group.Delay = 0
+	}
+
+	if group.Delay > network.GroupMaxDelay {
+		c.JSON(200, gin.H{
+			"err": fmt.Sprintf("唤醒间隔不能超过%d秒", network.GroupMaxDelay),
+		})
+		return
+	}
+
+	members := group.Members
+	group.Members = nil
+
+	dbObj := db.DBOperObj().GetDB()
+	err = dbObj.Transaction(func(tx *gorm.DB) error {
+		var result *gorm.DB
+		if group.ID == 0 {
+			result = tx.Create(group)
+		} else {
+			result = tx.Model(group).Select("name", "delay").Updates(group)
+		}
+
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return errors.New("分组不存在")
+		}
+
+		result = tx.Where("group_id=?", group.ID).Delete(&db.GroupMember{})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		for i := range members {
+			members[i].GroupID = group.ID
+		}
+
+		if len(members) != 0 {
+			result = tx.Create(&members)
+		}
+
+		return result.Error
+	})
+
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": err.Error(),
+		})
+		return
+	}
+
+	auditLog(c, "分组", "保存分组：%s，成员：%d，间隔：%d秒", group.Name, len(members), group.Delay)
+
+	c.JSON(200, gin.H{
+		"err":   "",
+		"infos": group.ID,
+	})
+}
+
+// 删除分组
+func (w *WakeApi) delGroup(c *gin.Context) {
+	id, err := strconv.Atoi(c.Query("id"))
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": "参数错误",
+		})
+		return
+	}
+
+	dbObj := db.DBOperObj().GetDB()
+	err = dbObj.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("group_id=?", id).Delete(&db.GroupMember{})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		return tx.Unscoped().Delete(&db.DeviceGroup{}, id).Error
+	})
+
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": err.Error(),
+		})
+		return
+	}
+
+	auditLog(c, "分组", "删除分组：%d", id)
+
+	c.JSON(200, gin.H{
+		"err": "",
+	})
+}
+
+// 分组唤醒，返回任务ID
+func (w *WakeApi) wakeGroup(c *gin.Context) {
+	id, err := strconv.Atoi(c.Query("id"))
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": "参数错误",
+		})
+		return
+	}
+
+	delay := -1
+	if strDelay, ok := c.GetQuery("delay"); ok {
+		delay, err = strconv.Atoi(strDelay)
+		if err != nil || delay < 0 || delay > network.GroupMaxDelay {
+			c.JSON(200, gin.H{
+				"err": "参数错误",
+			})
+			return
+		}
+	}
+
+	jobID, err := network.GroupWakeMG().Start(uint(id), delay)
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": err.Error(),
+		})
+		return
+	}
+
+	auditLog(c, "分组唤醒", "分组：%d，任务：%s", id, jobID)
+
+	c.JSON(200, gin.H{
+		"err":   "",
+		"infos": jobID,
+	})
+}
+
+// 推送分组唤醒进度
+func (w *WakeApi) wakeGroupStatus(c *gin.Context) {
+	pushJobStatus[network.GroupWakeInfo](c, network.GroupWakeMG(), c.Query("id"))
+}
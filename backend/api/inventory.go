@@ -0,0 +1,698 @@
+package api
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"wakelan/backend/comm"
+	"wakelan/backend/db"
+	"wakelan/backend/network"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 导入导出的机器信息
+type InventoryItem struct {
+	Mac      string `json:"mac"`
+	IP       string `json:"ip"`
+	MANUF    string `json:"manuf"`
+	Star     *bool  `json:"star"` //导入时为空表示未提供该字段
+	Describe string `json:"describe"`
+	Remote   string `json:"remote"`
+
+	DisplayName    string `json:"display_name"`
+	DeviceType     string `json:"device_type"`
+	VendorOverride string `json:"vendor_override"`
+	Tags           string `json:"tags"`
+	Notes          string `json:"notes"`
+}
+
+func (i *InventoryItem) starred() bool {
+	return i.Star != nil && *i.Star
+}
+
+// 导入差异，Action：add、update、delete
+type InventoryDiff struct {
+	Mac    string         `json:"mac"`
+	Action string         `json:"action"`
+	Fields []string       `json:"fields"`
+	Old    *InventoryItem `json:"old"`
+	New    *InventoryItem `json:"new"`
+}
+
+type InventoryResult struct {
+	DryRun    bool            `json:"dry_run"`
+	Mode      string          `json:"mode"`
+	Add       int             `json:"add"`
+	Update    int             `json:"update"`
+	Delete    int             `json:"delete"`
+	Unchanged int             `json:"unchanged"`
+	Diffs     []InventoryDiff `json:"diffs"`
+}
+
+// 远程连接配置中包含密码的字段
+var remotePwdKeys = []string{"remote", "sftp"}
+
+var inventoryHeader = []string{"mac", "ip", "manuf", "star", "describe", "remote",
+	"display_name", "device_type", "vendor_override", "tags", "notes"}
+
+// 读取当前的机器列表，按IP排序
+func loadInventory(dbObj *gorm.DB) ([]InventoryItem, error) {
+	infos := []db.MacInfo{}
+	result := dbObj.Joins("AttachInfo").Find(&infos)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return comm.IpLess(net.ParseIP(infos[i].IP), net.ParseIP(infos[j].IP))
+	})
+
+	items := []InventoryItem{}
+	for _, v := range infos {
+		star := v.AttachInfo.Star
+
+		items = append(items, InventoryItem{
+			Mac:      v.Mac,
+			IP:       v.IP,
+			MANUF:    v.MANUF,
+			Star:     &star,
+			Describe: v.AttachInfo.Describe,
+			Remote:   v.AttachInfo.Remote,
+
+			DisplayName:    v.AttachInfo.DisplayName,
+			DeviceType:     v.AttachInfo.DeviceType,
+			VendorOverride: v.AttachInfo.VendorOverride,
+			Tags:           v.AttachInfo.Tags,
+			Notes:          v.AttachInfo.Notes,
+		})
+	}
+
+	return items, nil
+}
+
+// 解析导入数据，format为空时按内容识别
+func parseInventory(data []byte, format string) ([]InventoryItem, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	if len(format) == 0 {
+		format = "csv"
+		if trim := bytes.TrimSpace(data); len(trim) != 0 && trim[0] == '[' {
+			format = "json"
+		}
+	}
+
+	items := []InventoryItem{}
+	first := 1 //第一条数据的位置，CSV的第一行为表头
+
+	switch format {
+	case "json":
+		err := json.Unmarshal(data, &items)
+		if err != nil {
+			return nil, err
+		}
+	case "csv":
+		records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(records) == 0 {
+			return nil, errors.New("empty data")
+		}
+
+		//按表头定位列，列的顺序和数量不限
+		cols := map[string]int{}
+		for i, v := range records[0] {
+			cols[strings.ToLower(strings.TrimSpace(v))] = i
+		}
+
+		if _, ok := cols["mac"]; !ok {
+			return nil, errors.New("mac column not found")
+		}
+
+		get := func(record []string, name string) string {
+			i, ok := cols[name]
+			if !ok || i >= len(record) {
+				return ""
+			}
+
+			return strings.TrimSpace(record[i])
+		}
+
+		first = 2
+
+		for _, record := range records[1:] {
+			var star *bool
+			if v := strings.ToLower(get(record, "star")); len(v) != 0 {
+				starred := v == "1" || v == "true" || v == "yes"
+				star = &starred
+			}
+
+			items = append(items, InventoryItem{
+				Mac:      get(record, "mac"),
+				IP:       get(record, "ip"),
+				MANUF:    get(record, "manuf"),
+				Star:     star,
+				Describe: get(record, "describe"),
+				Remote:   get(record, "remote"),
+
+				DisplayName:    get(record, "display_name"),
+				DeviceType:     get(record, "device_type"),
+				VendorOverride: get(record, "vendor_override"),
+				Tags:           get(record, "tags"),
+				Notes:          get(record, "notes"),
+			})
+		}
+	default:
+		return nil, errors.New("unknown format: " + format)
+	}
+
+	//校验并统一MAC格式
+	exists := map[string]bool{}
+	for i := range items {
+		line := i + first
+
+		mac, err := net.ParseMAC(items[i].Mac)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: mac %s is invalid", line, items[i].Mac)
+		}
+
+		items[i].Mac = mac.String()
+
+		if len(items[i].IP) != 0 && net.ParseIP(items[i].IP) == nil {
+			return nil, fmt.Errorf("line %d: ip %s is invalid", line, items[i].IP)
+		}
+
+		if len(items[i].Remote) != 0 && !json.Valid([]byte(items[i].Remote)) {
+			return nil, fmt.Errorf("line %d: remote is not json", line)
+		}
+
+		if exists[items[i].Mac] {
+			return nil, fmt.Errorf("line %d: mac %s is duplicated", line, items[i].Mac)
+		}
+
+		exists[items[i].Mac] = true
+	}
+
+	return items, nil
+}
+
+// 遍历远程连接配置中的密码，fun返回新的密码，id为连接的ID
+func mapRemotePwd(remote string, fun func(id string, key string, pwd string) (string, error)) (string, error) {
+	if len(remote) == 0 {
+		return remote, nil
+	}
+
+	confs := []map[string]interface{}{}
+	err := json.Unmarshal([]byte(remote), &confs)
+	if err != nil {
+		return "", err
+	}
+
+	for _, conf := range confs {
+		id := fmt.Sprint(conf["id"])
+
+		for _, key := range remotePwdKeys {
+			sub, ok := conf[key].(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			pwd, _ := sub["pwd"].(string)
+			pwd, err = fun(id, key, pwd)
+			if err != nil {
+				return "", err
+			}
+
+			sub["pwd"] = pwd
+		}
+	}
+
+	data, err := json.Marshal(confs)
+	return string(data), err
+}
+
+// 导出的远程连接配置：密码使用本机密钥加密，其他实例无法解密
+// credentials为true时导出明文密码，否则不导出密码
+func exportRemote(remote string, credentials bool) (string, error) {
+	cfg := db.DBOperObj().GetConfig()
+
+	return mapRemotePwd(remote, func(id string, key string, pwd string) (string, error) {
+		if !credentials || len(pwd) == 0 {
+			return "", nil
+		}
+
+		return comm.AES_CBC_OpenBase64(pwd, []byte(cfg.RandKey), []byte(comm.AttachIV))
+	})
+}
+
+// 导入的远程连接配置：credentials为true时密码为明文，使用本机密钥加密
+// 密码为空时保留该机器同一连接已保存的密码
+func importRemote(current []InventoryItem, items []InventoryItem, credentials bool) error {
+	cfg := db.DBOperObj().GetConfig()
+
+	olds := map[string]string{}
+	for _, v := range current {
+		olds[v.Mac] = v.Remote
+	}
+
+	for i := range items {
+		if len(items[i].Remote) == 0 {
+			continue
+		}
+
+		oldPwds := map[string]string{}
+		mapRemotePwd(olds[items[i].Mac], func(id string, key string, pwd string) (string, error) {
+			oldPwds[id+"."+key] = pwd
+			return pwd, nil
+		})
+
+		remote, err := mapRemotePwd(items[i].Remote, func(id string, key string, pwd string) (string, error) {
+			if len(pwd) == 0 {
+				return oldPwds[id+"."+key], nil
+			}
+
+			if !credentials {
+				return pwd, nil
+			}
+
+			return comm.AES_CBC_SealBase64(pwd, []byte(cfg.RandKey), []byte(comm.AttachIV))
+		})
+
+		if err != nil {
+			return fmt.Errorf("mac %s: remote is invalid, %s", items[i].Mac, err.Error())
+		}
+
+		items[i].Remote = remote
+	}
+
+	return nil
+}
+
+// 比较JSON内容，忽略字段顺序和格式
+func jsonEqual(a string, b string) bool {
+	if a == b {
+		return true
+	}
+
+	var va, vb interface{}
+	if json.Unmarshal([]byte(a), &va) != nil || json.Unmarshal([]byte(b), &vb) != nil {
+		return false
+	}
+
+	return reflect.DeepEqual(va, vb)
+}
+
+// 合并模式下导入的空字段保留原值
+func mergeInventoryItem(old InventoryItem, item InventoryItem) InventoryItem {
+	if len(item.IP) == 0 {
+		item.IP = old.IP
+	}
+
+	if item.Star == nil {
+		item.Star = old.Star
+	}
+
+	if len(item.MANUF) == 0 {
+		item.MANUF = old.MANUF
+	}
+
+	if len(item.Describe) == 0 {
+		item.Describe = old.Describe
+	}
+
+	if len(item.Remote) == 0 {
+		item.Remote = old.Remote
+	}
+
+	if len(item.DisplayName) == 0 {
+		item.DisplayName = old.DisplayName
+	}
+
+	if len(item.DeviceType) == 0 {
+		item.DeviceType = old.DeviceType
+	}
+
+	if len(item.VendorOverride) == 0 {
+		item.VendorOverride = old.VendorOverride
+	}
+
+	if len(item.Tags) == 0 {
+		item.Tags = old.Tags
+	}
+
+	if len(item.Notes) == 0 {
+		item.Notes = old.Notes
+	}
+
+	return item
+}
+
+func diffInventoryFields(old InventoryItem, item InventoryItem) []string {
+	fields := []string{}
+
+	if old.IP != item.IP {
+		fields = append(fields, "ip")
+	}
+
+	if old.MANUF != item.MANUF {
+		fields = append(fields, "manuf")
+	}
+
+	if old.starred() != item.starred() {
+		fields = append(fields, "star")
+	}
+
+	if old.Describe != item.Describe {
+		fields = append(fields, "describe")
+	}
+
+	if !jsonEqual(old.Remote, item.Remote) {
+		fields = append(fields, "remote")
+	}
+
+	if old.DisplayName != item.DisplayName {
+		fields = append(fields, "display_name")
+	}
+
+	if old.DeviceType != item.DeviceType {
+		fields = append(fields, "device_type")
+	}
+
+	if old.VendorOverride != item.VendorOverride {
+		fields = append(fields, "vendor_override")
+	}
+
+	if old.Tags != item.Tags {
+		fields = append(fields, "tags")
+	}
+
+	if old.Notes != item.Notes {
+		fields = append(fields, "notes")
+	}
+
+	return fields
+}
+
+// 计算导入差异，mode：merge只添加和更新，replace同时删除导入数据中没有的机器
+func diffInventory(current []InventoryItem, items []InventoryItem, mode string) InventoryResult {
+	ret := InventoryResult{
+		Mode:  mode,
+		Diffs: []InventoryDiff{},
+	}
+
+	olds := map[string]InventoryItem{}
+	for _, v := range current {
+		olds[v.Mac] = v
+	}
+
+	imported := map[string]bool{}
+	for _, item := range items {
+		item := item
+		imported[item.Mac] = true
+
+		old, ok := olds[item.Mac]
+		if ok && mode == "merge" {
+			item = mergeInventoryItem(old, item)
+		}
+
+		if item.Star == nil {
+			item.Star = new(bool)
+		}
+
+		if !ok {
+			ret.Add++
+			ret.Diffs = append(ret.Diffs, InventoryDiff{
+				Mac:    item.Mac,
+				Action: "add",
+				Fields: []string{},
+				New:    &item,
+			})
+			continue
+		}
+
+		fields := diffInventoryFields(old, item)
+		if len(fields) == 0 {
+			ret.Unchanged++
+			continue
+		}
+
+		ret.Update++
+		ret.Diffs = append(ret.Diffs, InventoryDiff{
+			Mac:    item.Mac,
+			Action: "update",
+			Fields: fields,
+			Old:    &old,
+			New:    &item,
+		})
+	}
+
+	if mode == "replace" {
+		for _, old := range current {
+			old := old
+			if imported[old.Mac] {
+				continue
+			}
+
+			ret.Delete++
+			ret.Diffs = append(ret.Diffs, InventoryDiff{
+				Mac:    old.Mac,
+				Action: "delete",
+				Fields: []string{},
+				Old:    &old,
+			})
+		}
+	}
+
+	return ret
+}
+
+// 写入差异
+func applyInventory(tx *gorm.DB, diffs []InventoryDiff) error {
+	for _, diff := range diffs {
+		if diff.Action == "delete" {
+			//同时删除分组成员、在线记录和以该机器为目标的定时任务
+			for _, model := range []interface{}{&db.MacInfo{}, &db.AttachInfo{}, &db.Service{},
+				&db.GroupMember{}, &db.Presence{}, &db.PresenceEvent{}} {
+				result := tx.Where("mac=?", diff.Mac).Delete(model)
+				if result.Error != nil {
+					return result.Error
+				}
+			}
+
+			result := tx.Where("target_type=? and target=?", "device", diff.Mac).Delete(&db.ScheduleJob{})
+			if result.Error != nil {
+				return result.Error
+			}
+			continue
+		}
+
+		item := diff.New
+
+		macInfo := &db.MacInfo{Mac: item.Mac}
+		result := tx.Model(macInfo).Updates(map[string]interface{}{
+			"ip":     item.IP,
+			"ip_num": db.IPNum(item.IP),
+			"manuf":  item.MANUF,
+		})
+
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			macInfo.IP = item.IP
+			macInfo.IPNum = db.IPNum(item.IP)
+			macInfo.MANUF = item.MANUF
+			result = tx.Omit("AttachInfo", "Services").Create(macInfo)
+			if result.Error != nil {
+				return result.Error
+			}
+		}
+
+		attach := &db.AttachInfo{Mac: item.Mac}
+		result = tx.Model(attach).Updates(map[string]interface{}{
+			"star":     item.starred(),
+			"describe": item.Describe,
+			"remote":   item.Remote,
+
+			"display_name":    item.DisplayName,
+			"device_type":     item.DeviceType,
+			"vendor_override": item.VendorOverride,
+			"tags":            item.Tags,
+			"notes":           item.Notes,
+		})
+
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			attach.Star = item.starred()
+			attach.Describe = item.Describe
+			attach.Remote = item.Remote
+			attach.DisplayName = item.DisplayName
+			attach.DeviceType = item.DeviceType
+			attach.VendorOverride = item.VendorOverride
+			attach.Tags = item.Tags
+			attach.Notes = item.Notes
+			result = tx.Create(attach)
+			if result.Error != nil {
+				return result.Error
+			}
+		}
+	}
+
+	return nil
+}
+
+// 导出机器列表，format：csv、json
+// 远程连接密码默认不导出，credentials=1时导出明文密码，需要管理员权限
+func (w *WakeApi) exportInventory(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+	credentials := c.Query("credentials") == "1"
+
+	if credentials && !isAdmin(c) {
+		c.JSON(200, gin.H{
+			"err": "权限不足",
+		})
+		return
+	}
+
+	items, err := loadInventory(db.DBOperObj().GetDB())
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": err.Error(),
+		})
+		return
+	}
+
+	for i := range items {
+		items[i].Remote, err = exportRemote(items[i].Remote, credentials)
+		if err != nil {
+			c.JSON(200, gin.H{
+				"err": fmt.Sprintf("mac %s: %s", items[i].Mac, err.Error()),
+			})
+			return
+		}
+	}
+
+	var data []byte
+
+	switch format {
+	case "json":
+		data, err = json.MarshalIndent(items, "", "  ")
+	case "csv":
+		buf := &bytes.Buffer{}
+		buf.WriteString("\xef\xbb\xbf") //Excel识别UTF-8
+
+		writer := csv.NewWriter(buf)
+		writer.Write(inventoryHeader)
+		for _, v := range items {
+			writer.Write([]string{v.Mac, v.IP, v.MANUF, strconv.FormatBool(v.starred()), v.Describe, v.Remote,
+				v.DisplayName, v.DeviceType, v.VendorOverride, v.Tags, v.Notes})
+		}
+
+		writer.Flush()
+		data, err = buf.Bytes(), writer.Error()
+	default:
+		err = errors.New("unknown format: " + format)
+	}
+
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": err.Error(),
+		})
+		return
+	}
+
+	fName := fmt.Sprintf("wakelan_%s.%s", time.Now().Format("20060102150405"), format)
+
+	auditLog(c, "机器导入导出", "导出 %d 台机器，格式：%s，包含密码：%t", len(items), format, credentials)
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", fName))
+	c.Data(200, "application/octet-stream", data)
+}
+
+// 导入机器列表，请求体为CSV或JSON数据
+// mode：merge合并（默认），replace替换；dryrun=1时只返回差异不写入
+// credentials=1时远程连接密码为明文，导入后使用本机密钥加密
+func (w *WakeApi) importInventory(c *gin.Context) {
+	format := c.Query("format")
+	mode := c.DefaultQuery("mode", "merge")
+	dryRun := c.DefaultQuery("dryrun", "1") == "1"
+	credentials := c.Query("credentials") == "1"
+
+	if mode != "merge" && mode != "replace" {
+		c.JSON(200, gin.H{
+			"err": "参数错误",
+		})
+		return
+	}
+
+	data, _ := c.GetRawData()
+	items, err := parseInventory(data, format)
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": err.Error(),
+		})
+		return
+	}
+
+	var ret InventoryResult
+
+	dbObj := db.DBOperObj().GetDB()
+	err = dbObj.Transaction(func(tx *gorm.DB) error {
+		current, err := loadInventory(tx)
+		if err != nil {
+			return err
+		}
+
+		err = importRemote(current, items, credentials)
+		if err != nil {
+			return err
+		}
+
+		ret = diffInventory(current, items, mode)
+		ret.DryRun = dryRun
+
+		if dryRun {
+			return nil
+		}
+
+		return applyInventory(tx, ret.Diffs)
+	})
+
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": err.Error(),
+		})
+		return
+	}
+
+	if !dryRun {
+		for _, diff := range ret.Diffs {
+			if diff.Action == "delete" {
+				network.AlertObj().SetTrusted(diff.Mac, false)
+			}
+		}
+
+		auditLog(c, "机器导入导出", "导入 %d 台机器，模式：%s，添加：%d，更新：%d，删除：%d",
+			len(items), mode, ret.Add, ret.Update, ret.Delete)
+	}
+
+	c.JSON(200, gin.H{
+		"err":   "",
+		"infos": ret,
+	})
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"net/http"
+	"sync"
+	"wakelan/backend/network"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// 通过websocket推送任务状态，任务结束或连接断开时返回
+func pushJobStatus[T network.JobInfo](c *gin.Context, mg network.JobSubscriber[T], id string) {
+	wbsocket := websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			return true
+		},
+	}
+
+	conn, err := wbsocket.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": err.Error(),
+		})
+
+		return
+	}
+
+	defer conn.Close()
+
+	done := make(chan struct{})
+	lock := sync.Mutex{}
+	flag := conn.RemoteAddr().String()
+
+	closeFun := func() {
+		select {
+		case <-done:
+		default:
+			close(done)
+		}
+	}
+
+	writeFun := func(info T) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		conn.WriteJSON(info)
+
+		if info.IsDone() {
+			closeFun()
+		}
+	}
+
+	info, err := mg.Subscribe(id, flag, writeFun)
+	if err != nil {
+		conn.WriteJSON(gin.H{
+			"err": err.Error(),
+		})
+		return
+	}
+
+	defer mg.Unsubscribe(id, flag)
+
+	writeFun(info)
+
+	go func() {
+		for {
+			_, _, err := conn.ReadMessage()
+			if err != nil {
+				lock.Lock()
+				closeFun()
+				lock.Unlock()
+				break
+			}
+		}
+	}()
+
+	<-done
+}
@@ -0,0 +1,212 @@
+package api
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"wakelan/backend/db"
+	"wakelan/backend/network"
+)
+
+const (
+	loginWindow      = time.Minute      //限流统计周期
+	loginIPRate      = 10               //单个IP每周期最多尝试次数
+	loginGlobalRate  = 60               //所有IP每周期最多尝试次数
+	loginFreeFails   = 3                //连续失败该次数后开始退避
+	loginMaxBackoff  = 5 * time.Minute  //最长退避时间
+	loginLockFails   = 10               //连续失败该次数后锁定
+	loginLockTime    = 15 * time.Minute //锁定时间
+	loginFailExpire  = time.Hour        //失败记录保留时间
+	loginGlobalAlert = 10 * time.Minute //全局限流通知的最小间隔
+)
+
+type loginRecord struct {
+	fails       int       //连续失败次数
+	lastFail    time.Time //最近失败时间
+	lockUntil   time.Time //锁定截止时间
+	windowStart time.Time
+	attempts    int //当前周期尝试次数
+}
+
+// 登录防暴力破解：按IP和全局限流，按IP和用户名分别统计连续失败，指数退避并临时锁定
+type LoginGuard struct {
+	lock        sync.Mutex
+	records     map[string]*loginRecord //按IP统计
+	users       map[string]*loginRecord //按用户名统计
+	windowStart time.Time
+	attempts    int
+	lastAlert   time.Time
+}
+
+func getLoginRecord(records map[string]*loginRecord, key string, now time.Time) *loginRecord {
+	r, ok := records[key]
+	if !ok {
+		//清理过期记录
+		if len(records) > 1024 {
+			for k, v := range records {
+				if now.Sub(v.lastFail) >= loginFailExpire && now.After(v.lockUntil) && now.Sub(v.windowStart) >= loginWindow {
+					delete(records, k)
+				}
+			}
+		}
+
+		r = &loginRecord{}
+		records[key] = r
+	}
+
+	return r
+}
+
+// 锁定或退避中返回需要等待的时间
+func (r *loginRecord) wait(now time.Time) time.Duration {
+	if now.Before(r.lockUntil) {
+		return r.lockUntil.Sub(now)
+	}
+
+	if r.fails >= loginLockFails {
+		//锁定结束后重新计数
+		r.fails = 0
+	}
+
+	if now.Sub(r.lastFail) >= loginFailExpire {
+		r.fails = 0
+	}
+
+	return r.lastFail.Add(loginBackoff(r.fails)).Sub(now)
+}
+
+// 记录一次失败，返回是否因此锁定
+func (r *loginRecord) fail(now time.Time) bool {
+	r.fails++
+	r.lastFail = now
+
+	if r.fails >= loginLockFails {
+		r.lockUntil = now.Add(loginLockTime)
+		return true
+	}
+
+	return false
+}
+
+// 连续失败的退避时间：超过免退避次数后按2的幂增长
+func loginBackoff(fails int) time.Duration {
+	if fails < loginFreeFails {
+		return 0
+	}
+
+	n := fails - loginFreeFails
+	if n > 10 {
+		return loginMaxBackoff
+	}
+
+	backoff := time.Second << n
+	if backoff > loginMaxBackoff {
+		backoff = loginMaxBackoff
+	}
+
+	return backoff
+}
+
+// 检查是否允许尝试登录，不允许时返回需要等待的时间
+func (l *LoginGuard) Check(ip string, user string) (bool, time.Duration) {
+	now := time.Now()
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	r := getLoginRecord(l.records, ip, now)
+
+	wait := r.wait(now)
+	if wait > 0 {
+		return false, wait
+	}
+
+	wait = getLoginRecord(l.users, user, now).wait(now)
+	if wait > 0 {
+		return false, wait
+	}
+
+	if now.Sub(r.windowStart) >= loginWindow {
+		r.windowStart = now
+		r.attempts = 0
+	}
+
+	if r.attempts >= loginIPRate {
+		return false, r.windowStart.Add(loginWindow).Sub(now)
+	}
+
+	if now.Sub(l.windowStart) >= loginWindow {
+		l.windowStart = now
+		l.attempts = 0
+	}
+
+	if l.attempts >= loginGlobalRate {
+		if now.Sub(l.lastAlert) >= loginGlobalAlert {
+			l.lastAlert = now
+			l.notify("登录尝试过于频繁，已临时限制所有登录，最近来源IP：%s", ip)
+		}
+
+		return false, l.windowStart.Add(loginWindow).Sub(now)
+	}
+
+	r.attempts++
+	l.attempts++
+
+	return true, 0
+}
+
+// 记录登录失败，返回连续失败次数
+func (l *LoginGuard) Fail(ip string, user string) int {
+	now := time.Now()
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	r := getLoginRecord(l.records, ip, now)
+	if r.fail(now) {
+		l.notify("登录连续失败 %d 次，IP：%s，用户：%s，已锁定 %d 分钟", r.fails, ip, user, int(loginLockTime/time.Minute))
+	} else if r.fails == loginFreeFails {
+		l.notify("登录连续失败 %d 次，IP：%s，用户：%s", r.fails, ip, user)
+	}
+
+	u := getLoginRecord(l.users, user, now)
+	if u.fail(now) {
+		l.notify("用户 %s 登录连续失败 %d 次，已锁定 %d 分钟，最近来源IP：%s", user, u.fails, int(loginLockTime/time.Minute), ip)
+	}
+
+	return r.fails
+}
+
+// 登录成功，清除失败记录
+func (l *LoginGuard) Success(ip string, user string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for _, r := range []*loginRecord{l.records[ip], l.users[user]} {
+		if r != nil {
+			r.fails = 0
+			r.lockUntil = time.Time{}
+		}
+	}
+}
+
+// 记录日志并推送通知
+func (l *LoginGuard) notify(format string, a ...any) {
+	msg := fmt.Sprintf(format, a...)
+	db.DBLog("登录告警", "%s", msg)
+	network.PushMsgAsync(msg)
+}
+
+var loginGuardOnce sync.Once
+var loginGuardObj *LoginGuard
+
+func LoginGuardObj() *LoginGuard {
+	loginGuardOnce.Do(func() {
+		loginGuardObj = &LoginGuard{
+			records: make(map[string]*loginRecord),
+			users:   make(map[string]*loginRecord),
+		}
+	})
+
+	return loginGuardObj
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	"wakelan/backend/comm"
+	"wakelan/backend/db"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 在线时间段
+type PresenceSegment struct {
+	Online bool   `json:"online"`
+	Start  string `json:"start"`
+	End    string `json:"end"`
+	Second int64  `json:"second"`
+}
+
+type PresenceTimeline struct {
+	Current  *db.Presence       `json:"current"`
+	Events   []db.PresenceEvent `json:"events"`
+	Segments []PresenceSegment  `json:"segments"`
+	Uptime   float64            `json:"uptime"` //在线比例，0-1
+}
+
+// 获取所有机器当前在线状态
+func (w *WakeApi) getPresence(c *gin.Context) {
+	infos := []db.Presence{}
+	dbObj := db.DBOperObj().GetDB()
+
+	result := dbObj.Order("mac").Find(&infos)
+	if result.Error != nil {
+		c.JSON(200, gin.H{
+			"err": result.Error.Error(),
+		})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"err":   "",
+		"infos": infos,
+	})
+}
+
+// 获取机器上下线时间线，days为统计天数，默认7天
+func (w *WakeApi) getPresenceTimeline(c *gin.Context) {
+	mac := strings.ToLower(c.Query("mac"))
+	if len(mac) == 0 {
+		c.JSON(200, gin.H{
+			"err": "MAC不能为空",
+		})
+		return
+	}
+
+	days, err := strconv.Atoi(c.DefaultQuery("days", "7"))
+	if err != nil || days <= 0 {
+		days = 7
+	}
+
+	now := time.Now()
+	begin := now.AddDate(0, 0, -days)
+	dbObj := db.DBOperObj().GetDB()
+
+	timeline := PresenceTimeline{}
+
+	current := &db.Presence{}
+	result := dbObj.Where("mac=?", mac).Limit(1).Find(current)
+	if result.Error != nil {
+		c.JSON(200, gin.H{
+			"err": result.Error.Error(),
+		})
+		return
+	}
+
+	if result.RowsAffected != 0 {
+		timeline.Current = current
+	}
+
+	result = dbObj.Where("mac=? and time>=?", mac, begin).Order("time").Find(&timeline.Events)
+	if result.Error != nil {
+		c.JSON(200, gin.H{
+			"err": result.Error.Error(),
+		})
+		return
+	}
+
+	//统计开始时的状态
+	last := db.PresenceEvent{}
+	dbObj.Where("mac=? and time<?", mac, begin).Order("time desc").Limit(1).Find(&last)
+
+	state := last.ID != 0 && last.Online
+	start := begin
+	var onlineSecond int64
+
+	addSegment := func(end time.Time) {
+		second := int64(end.Sub(start).Seconds())
+		if second <= 0 {
+			return
+		}
+
+		if state {
+			onlineSecond += second
+		}
+
+		timeline.Segments = append(timeline.Segments, PresenceSegment{
+			Online: state,
+			Start:  start.Format(comm.TimeFormat),
+			End:    end.Format(comm.TimeFormat),
+			Second: second,
+		})
+	}
+
+	for _, e := range timeline.Events {
+		if e.Online == state {
+			continue
+		}
+
+		addSegment(e.Time)
+		state = e.Online
+		start = e.Time
+	}
+
+	addSegment(now)
+
+	total := now.Sub(begin).Seconds()
+	if total > 0 {
+		timeline.Uptime = float64(onlineSecond) / total
+	}
+
+	c.JSON(200, gin.H{
+		"err":   "",
+		"infos": timeline,
+	})
+}
@@ -1,12 +1,10 @@
 package api
 
 import (
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
-	"strings"
 	"wakelan/backend/comm"
 	"wakelan/backend/db"
 	"wakelan/backend/guacd"
@@ -32,29 +30,11 @@ func (r *Remote) Init() {
 
 	cfg := db.DBOperObj().GetConfig()
 	r.key = []byte(cfg.RandKey)
-	r.iv = []byte("41FD220EB4878B42")
+	r.iv = []byte(comm.AttachIV)
 }
 
 func (r *Remote) decrypt(decData string) (string, error) {
-	for len(decData)%4 != 0 {
-		decData += "="
-	}
-
-	data, err := base64.URLEncoding.DecodeString(decData)
-	if err != nil {
-		return "", err
-	}
-
-	data, err = comm.AES_CBC_Open(data, r.key, r.iv)
-	if err != nil {
-		return "", err
-	}
-
-	if len(data) == 0 {
-		return "", nil
-	}
-
-	return strings.TrimRight(string(data), "\x00"), nil
+	return comm.AES_CBC_OpenBase64(decData, r.key, r.iv)
 }
 
 func (r *Remote) setting(c *gin.Context) {
@@ -143,7 +123,7 @@ func (r *Remote) remote(c *gin.Context) {
 	info.Remote.Pwd, _ = r.decrypt(info.Remote.Pwd)
 	info.Sftp.Pwd, _ = r.decrypt(info.Sftp.Pwd)
 
-	db.DBLog("远程连接", "主机：%s，类型：%v，Guacd：%s:%d",
+	auditLog(c, "远程连接", "主机：%s，类型：%v，Guacd：%s:%d",
 		info.Remote.Host,
 		r.t2s[info.Remote.Type],
 		cfg.GuacdHost, cfg.GuacdPort)
@@ -176,7 +156,7 @@ func (r *Remote) remote(c *gin.Context) {
 		})
 	}
 
-	db.DBLog("远程断开", "主机：%s，类型：%v，Guacd：%s:%d",
+	auditLog(c, "远程断开", "主机：%s，类型：%v，Guacd：%s:%d",
 		info.Remote.Host, r.t2s[info.Remote.Type],
 		cfg.GuacdHost, cfg.GuacdPort)
 }
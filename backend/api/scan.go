@@ -0,0 +1,77 @@
+package api
+
+import (
+	"strconv"
+	"wakelan/backend/db"
+	"wakelan/backend/network"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 推送扫描进度
+func (w *WakeApi) scanStatus(c *gin.Context) {
+	pushJobStatus[network.ScanJobInfo](c, network.ScanJobMG(), c.Query("id"))
+}
+
+// 取消扫描
+func (w *WakeApi) cancelScan(c *gin.Context) {
+	err := network.ScanJobMG().Cancel(c.Query("id"))
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"err": "",
+	})
+}
+
+// 获取扫描记录，默认最近20条
+func (w *WakeApi) getScanRecords(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit <= 0 {
+		limit = 20
+	}
+
+	records := []db.ScanRecord{}
+	dbObj := db.DBOperObj().GetDB()
+
+	result := dbObj.Order("begin desc").Limit(limit).Find(&records)
+	if result.Error != nil {
+		c.JSON(200, gin.H{
+			"err": result.Error.Error(),
+		})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"err":   "",
+		"infos": records,
+	})
+}
+
+// 删除扫描记录，id为空时删除全部
+func (w *WakeApi) delScanRecord(c *gin.Context) {
+	id := c.Query("id")
+	dbObj := db.DBOperObj().GetDB()
+
+	tx := dbObj.Where("1=1")
+	if len(id) != 0 {
+		tx = dbObj.Where("id=?", id)
+	}
+
+	result := tx.Delete(&db.ScanRecord{})
+
+	if result.Error != nil {
+		c.JSON(200, gin.H{
+			"err": result.Error.Error(),
+		})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"err": "",
+	})
+}
@@ -0,0 +1,194 @@
+package api
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+	"wakelan/backend/comm"
+	"wakelan/backend/db"
+	"wakelan/backend/schedule"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type ScheduleInfo struct {
+	db.ScheduleJob
+	NextRun string `json:"next_run"`
+}
+
+type Schedule struct {
+}
+
+func (s *Schedule) Init() {
+	schedule.SchedulerObj().Start()
+}
+
+// 获取定时任务
+func (s *Schedule) GetJobs(c *gin.Context) {
+	jobs := []db.ScheduleJob{}
+	dbObj := db.DBOperObj().GetDB()
+	result := dbObj.Order("id").Find(&jobs)
+	if result.Error != nil {
+		c.JSON(200, gin.H{
+			"err": result.Error.Error(),
+		})
+		return
+	}
+
+	infos := []ScheduleInfo{}
+	now := time.Now()
+
+	for _, job := range jobs {
+		info := ScheduleInfo{ScheduleJob: job}
+
+		cron, err := schedule.ParseCron(job.Cron)
+		if err == nil && job.Enable {
+			next := cron.Next(now)
+			if !next.IsZero() {
+				info.NextRun = next.Format(comm.TimeFormat)
+			}
+		}
+
+		infos = append(infos, info)
+	}
+
+	c.JSON(200, gin.H{
+		"err":   "",
+		"infos": infos,
+	})
+}
+
+// 添加或修改定时任务，id为0时添加
+func (s *Schedule) SaveJob(c *gin.Context) {
+	data, _ := c.GetRawData()
+
+	job := &db.ScheduleJob{}
+	err := json.Unmarshal(data, job)
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": "参数错误",
+		})
+		return
+	}
+
+	err = schedule.CheckJob(job)
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": err.Error(),
+		})
+		return
+	}
+
+	dbObj := db.DBOperObj().GetDB()
+
+	var result *gorm.DB
+	if job.ID == 0 {
+		result = dbObj.Create(job)
+	} else {
+		result = dbObj.Model(job).Select("name", "cron", "action", "target_type", "target", "enable").Updates(job)
+	}
+
+	if result.Error != nil {
+		c.JSON(200, gin.H{
+			"err": result.Error.Error(),
+		})
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		c.JSON(200, gin.H{
+			"err": "任务不存在",
+		})
+		return
+	}
+
+	auditLog(c, "定时任务", "保存任务：%s，表达式：%s，动作：%s，目标：%s", job.Name, job.Cron, job.Action, job.Target)
+
+	c.JSON(200, gin.H{
+		"err":   "",
+		"infos": job.ID,
+	})
+}
+
+// 删除定时任务
+func (s *Schedule) DelJob(c *gin.Context) {
+	id, err := strconv.Atoi(c.Query("id"))
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": "参数错误",
+		})
+		return
+	}
+
+	dbObj := db.DBOperObj().GetDB()
+	result := dbObj.Delete(&db.ScheduleJob{}, id)
+	if result.Error != nil {
+		c.JSON(200, gin.H{
+			"err": result.Error.Error(),
+		})
+		return
+	}
+
+	auditLog(c, "定时任务", "删除任务：%d", id)
+
+	c.JSON(200, gin.H{
+		"err": "",
+	})
+}
+
+// 启用或停用定时任务
+func (s *Schedule) EnableJob(c *gin.Context) {
+	id, err := strconv.Atoi(c.Query("id"))
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": "参数错误",
+		})
+		return
+	}
+
+	enable := c.Query("enable") == "1"
+
+	dbObj := db.DBOperObj().GetDB()
+	result := dbObj.Model(&db.ScheduleJob{}).Where("id=?", id).Update("enable", enable)
+	if result.Error != nil {
+		c.JSON(200, gin.H{
+			"err": result.Error.Error(),
+		})
+		return
+	}
+
+	auditLog(c, "定时任务", "任务：%d，启用：%t", id, enable)
+
+	c.JSON(200, gin.H{
+		"err": "",
+	})
+}
+
+// 立即执行定时任务，执行结果记录在任务的最近执行结果中
+func (s *Schedule) RunJob(c *gin.Context) {
+	job := db.ScheduleJob{}
+	dbObj := db.DBOperObj().GetDB()
+	result := dbObj.Where("id=?", c.Query("id")).First(&job)
+	if result.Error != nil {
+		c.JSON(200, gin.H{
+			"err": result.Error.Error(),
+		})
+		return
+	}
+
+	auditLog(c, "定时任务", "立即执行：%s，动作：%s，目标：%s", job.Name, job.Action, job.Target)
+
+	//分组唤醒按间隔依次执行，耗时较长，在后台执行
+	err := schedule.SchedulerObj().RunAsync(job)
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"err": "",
+	})
+}
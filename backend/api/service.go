@@ -0,0 +1,74 @@
+package api
+
+import (
+	"strings"
+	"wakelan/backend/db"
+	"wakelan/backend/network"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 探测机器服务，ports为额外探测的端口，如：8080,9000-9010
+func (w *WakeApi) probeService(c *gin.Context) {
+	mac := strings.ToLower(c.Query("mac"))
+	if len(mac) == 0 {
+		c.JSON(200, gin.H{
+			"err": "MAC不能为空",
+		})
+		return
+	}
+
+	ports, err := network.ParsePorts(c.Query("ports"))
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": "端口格式错误",
+		})
+		return
+	}
+
+	//未指定IP时使用记录的IP
+	ip := c.Query("ip")
+	if len(ip) == 0 {
+		info := &db.MacInfo{}
+		db.DBOperObj().GetDB().Where("mac=?", mac).Find(info)
+		ip = info.IP
+	}
+
+	services, err := network.ServiceProberObj().Probe(mac, ip, ports)
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"err":   "",
+		"infos": services,
+	})
+}
+
+// 获取机器服务
+func (w *WakeApi) getServices(c *gin.Context) {
+	mac := strings.ToLower(c.Query("mac"))
+	if len(mac) == 0 {
+		c.JSON(200, gin.H{
+			"err": "MAC不能为空",
+		})
+		return
+	}
+
+	services := []db.Service{}
+	result := db.DBOperObj().GetDB().Where("mac=?", mac).Order("port").Find(&services)
+	if result.Error != nil {
+		c.JSON(200, gin.H{
+			"err": result.Error.Error(),
+		})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"err":   "",
+		"infos": services,
+	})
+}
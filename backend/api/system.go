@@ -2,26 +2,19 @@ package api
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
+	"io"
 	"strconv"
 	"wakelan/backend/db"
+	"wakelan/backend/network"
 
 	"github.com/gin-gonic/gin"
-	"github.com/pquerna/otp"
-	"github.com/pquerna/otp/totp"
 )
 
-type DynamicPassword struct {
-	AuthURL string `gorm:"column:auth_url"  json:"auth_url"`
-	Secret  string `gorm:"column:secret"  json:"secret"`
-}
-
 type ConfigInfo struct {
 	IP        string `gorm:"column:ip" json:"ip"`
 	GuacdHost string `gorm:"column:guacd_host"  json:"guacd_host"`
 	GuacdPort int    `gorm:"column:guacd_port"  json:"guacd_port"`
-	AuthURL   string `gorm:"column:auth_url"  json:"auth_url"`
-	Secret    string `gorm:"column:secret"  json:"secret"`
 
 	AYFFToken       string `gorm:"column:ayff_token"  json:"ayff_token"`
 	WXPusherToken   string `gorm:"column:wxpusher_token"  json:"wxpusher_token"`
@@ -38,6 +31,18 @@ type ConfigInfo struct {
 	DockerPasswd      string `gorm:"docker_passwd" json:"docker_passwd"`
 
 	CheckIPAddr string `gorm:"column:check_ip_addr;" json:"check_ip_addr"`
+
+	RelayEnable bool `gorm:"column:relay_enable" json:"relay_enable"`
+	RelayPort   int  `gorm:"column:relay_port" json:"relay_port"`
+
+	PresenceInterval int `gorm:"column:presence_interval" json:"presence_interval"`
+
+	ServiceProbe bool   `gorm:"column:service_probe" json:"service_probe"`
+	ServicePorts string `gorm:"column:service_ports" json:"service_ports"`
+
+	AlertEnable bool `gorm:"column:alert_enable" json:"alert_enable"`
+
+	SessionIdle int `gorm:"column:session_idle" json:"session_idle"`
 }
 
 type System struct {
@@ -105,8 +110,7 @@ func (r *System) GetConfigInfo(c *gin.Context) {
 	cfg.IP = info.IP
 	cfg.GuacdHost = info.GuacdHost
 	cfg.GuacdPort = info.GuacdPort
-	cfg.AuthURL = info.AuthURL
-	cfg.Secret = info.Secret
+
 	cfg.AYFFToken = info.AYFFToken
 	cfg.WXPusherToken = info.WXPusherToken
 	cfg.WXPusherTopicId = info.WXPusherTopicId
@@ -126,6 +130,14 @@ func (r *System) GetConfigInfo(c *gin.Context) {
 
 	cfg.CheckIPAddr = info.CheckIPAddr
 
+	cfg.RelayEnable = info.RelayEnable
+	cfg.RelayPort = info.RelayPort
+	cfg.PresenceInterval = info.PresenceInterval
+	cfg.ServiceProbe = info.ServiceProbe
+	cfg.ServicePorts = info.ServicePorts
+	cfg.AlertEnable = info.AlertEnable
+	cfg.SessionIdle = info.SessionIdle
+
 	c.JSON(200, gin.H{
 		"err":   "",
 		"infos": cfg,
@@ -153,8 +165,6 @@ func (r *System) SetConfig(c *gin.Context) {
 	cfg.SharedLimit = cfgInfo.SharedLimit
 	cfg.GuacdHost = cfgInfo.GuacdHost
 	cfg.GuacdPort = cfgInfo.GuacdPort
-	cfg.AuthURL = cfgInfo.AuthURL
-	cfg.Secret = cfgInfo.Secret
 	cfg.AYFFToken = cfgInfo.AYFFToken
 	cfg.WXPusherToken = cfgInfo.WXPusherToken
 	cfg.WXPusherTopicId = cfgInfo.WXPusherTopicId
@@ -164,13 +174,44 @@ func (r *System) SetConfig(c *gin.Context) {
 	cfg.DockerUser = cfgInfo.DockerUser
 	cfg.DockerPasswd = cfgInfo.DockerPasswd
 	cfg.CheckIPAddr = cfgInfo.CheckIPAddr
+	cfg.RelayEnable = cfgInfo.RelayEnable
+	cfg.RelayPort = cfgInfo.RelayPort
+	cfg.PresenceInterval = cfgInfo.PresenceInterval
+	cfg.ServiceProbe = cfgInfo.ServiceProbe
+	cfg.ServicePorts = cfgInfo.ServicePorts
+	cfg.AlertEnable = cfgInfo.AlertEnable
+	cfg.SessionIdle = cfgInfo.SessionIdle
+	if cfg.SessionIdle < 0 {
+		cfg.SessionIdle = 0
+	}
 
-	dbObj.Select("guacd_host", "guacd_port", "auth_url", "secret",
-		"ayff_token", "wxpusher_token", "wxpusher_topicid",
+	_, err = network.ParsePorts(cfg.ServicePorts)
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err":   "自定义端口格式错误",
+			"infos": "",
+		})
+		return
+	}
+
+	dbObj.Select("guacd_host", "guacd_port", "ayff_token", "wxpusher_token", "wxpusher_topicid",
 		"debug", "shared_limit", "check_ip_addr", "docker_enable_tcp",
-		"docker_svr_ip", "docker_svr_port", "docker_user", "docker_passwd").Save(cfg)
+		"docker_svr_ip", "docker_svr_port", "docker_user", "docker_passwd",
+		"relay_enable", "relay_port", "presence_interval",
+		"service_probe", "service_ports", "alert_enable", "session_idle").Save(cfg)
 
 	db.DBOperObj().SwitchLogger()
+	network.AlertObj().Reload()
+	network.ServiceProberObj().Reload()
+
+	err = network.WakeRelayObj().Reload()
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err":   "唤醒中继启动失败，err:" + err.Error(),
+			"infos": "",
+		})
+		return
+	}
 
 	c.JSON(200, gin.H{
 		"err":   "",
@@ -178,31 +219,72 @@ func (r *System) SetConfig(c *gin.Context) {
 	})
 }
 
-// 生成动态密码
-func (r *System) GenDynamicPassword(c *gin.Context) {
-	key, err := totp.Generate(totp.GenerateOpts{
-		Issuer:      "网络唤醒",
-		AccountName: "gwbc",
-		Algorithm:   otp.AlgorithmSHA512,
+// 上传厂商数据库，支持Wireshark manuf和IEEE oui.csv/mam.csv/oui36.csv，立即生效
+func (r *System) UploadManuf(c *gin.Context) {
+	const maxSize = 32 << 20
+
+	var data []byte
+	var fileName string
+	var count int
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		goto UploadErr
+	}
+
+	if file.Size > maxSize {
+		err = errors.New("file too large")
+		goto UploadErr
+	}
+
+	{
+		src, openErr := file.Open()
+		if openErr != nil {
+			err = openErr
+			goto UploadErr
+		}
+
+		data, err = io.ReadAll(io.LimitReader(src, maxSize))
+		src.Close()
+		if err != nil {
+			goto UploadErr
+		}
+	}
+
+	fileName, count, err = network.UpdateManuf(data)
+	if err != nil {
+		goto UploadErr
+	}
+
+	auditLog(c, "厂商数据库", "上传 %s，共 %d 条", fileName, count)
+
+	c.JSON(200, gin.H{
+		"err":   "",
+		"infos": count,
+	})
+
+	return
+
+UploadErr:
+	c.JSON(200, gin.H{
+		"err":   err.Error(),
+		"infos": "",
 	})
+}
 
+// 轮换登录Token密钥，已签发的Token在会话有效期内仍可使用
+func (r *System) RotateToken(c *gin.Context) {
+	err := rotateLoginToken()
 	if err != nil {
 		c.JSON(200, gin.H{
-			"err":   err.Error(),
-			"infos": "",
+			"err": err.Error(),
 		})
-
 		return
 	}
 
-	pwd := DynamicPassword{}
-
-	//不能使用key.URL，手机动态识别的用户信息错误
-	pwd.AuthURL = fmt.Sprintf("otpauth://totp/%s?secret=%s&issuer=%s", key.AccountName(), key.Secret(), key.Issuer())
-	pwd.Secret = key.Secret()
+	auditLog(c, "Token", "轮换登录密钥")
 
 	c.JSON(200, gin.H{
-		"err":   "",
-		"infos": pwd,
+		"err": "",
 	})
 }
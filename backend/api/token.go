@@ -0,0 +1,95 @@
+package api
+
+import (
+	"sync"
+	"time"
+	"wakelan/backend/comm"
+	"wakelan/backend/db"
+)
+
+const (
+	tokenScopeLogin  = "login"
+	tokenScopeShare  = "share"
+	loginTokenRotate = 30 * 24 * time.Hour //登录密钥轮换周期
+	shareTokenExpire = 6 * time.Hour       //共享key有效期
+	shareTokenRotate = 24 * time.Hour      //共享密钥轮换周期
+)
+
+var tokenManagerObj *comm.TokenManager
+var tokenManagerOnce sync.Once
+
+// 登录Token，密钥保存在数据库，重启后已登录的会话仍有效
+func TokenManager() *comm.TokenManager {
+	tokenManagerOnce.Do(func() {
+		obj := &comm.TokenManager{}
+
+		keys, err := db.GetTokenKeys(tokenScopeLogin)
+		if err != nil {
+			db.DBLog("Token", "加载登录密钥失败：%s", err.Error())
+		}
+
+		err = obj.Init(tokenScopeLogin, db.SessionExpire, keys...)
+		if err != nil {
+			db.DBLog("Token", "登录密钥无效，重新生成：%s", err.Error())
+			obj.Init(tokenScopeLogin, db.SessionExpire)
+		}
+
+		tokenManagerObj = obj
+		saveLoginTokenKeys()
+
+		go autoRotateToken()
+	})
+
+	return tokenManagerObj
+}
+
+var fileSharedObj *comm.TokenManager
+var fileSharedOnce sync.Once
+
+// 文件共享key，密钥只保存在内存
+func FileSharedMG() *comm.TokenManager {
+	fileSharedOnce.Do(func() {
+		obj := &comm.TokenManager{}
+		obj.Init(tokenScopeShare, shareTokenExpire)
+		fileSharedObj = obj
+	})
+
+	return fileSharedObj
+}
+
+func saveLoginTokenKeys() error {
+	err := db.SaveTokenKeys(tokenScopeLogin, tokenManagerObj.Keys())
+	if err != nil {
+		db.DBLog("Token", "保存登录密钥失败：%s", err.Error())
+	}
+
+	return err
+}
+
+// 轮换登录密钥，旧密钥在会话有效期内仍可校验
+func rotateLoginToken() error {
+	err := TokenManager().Rotate()
+	if err != nil {
+		return err
+	}
+
+	return saveLoginTokenKeys()
+}
+
+// 定期轮换密钥
+func autoRotateToken() {
+	for {
+		if time.Since(TokenManager().KeyCreated()) >= loginTokenRotate {
+			err := rotateLoginToken()
+			if err == nil {
+				db.DBLog("Token", "登录密钥已轮换")
+			}
+		}
+
+		if time.Since(FileSharedMG().KeyCreated()) >= shareTokenRotate {
+			FileSharedMG().Rotate()
+		}
+
+		time.Sleep(1 * time.Hour)
+	}
+}
@@ -0,0 +1,309 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image/png"
+	"net/url"
+	"strconv"
+	"wakelan/backend/db"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"gorm.io/gorm"
+)
+
+const (
+	totpIssuer = "网络唤醒"
+	qrCodeSize = 200
+)
+
+type DynamicPassword struct {
+	AuthURL string `json:"auth_url"`
+	Secret  string `json:"secret"`
+	QRCode  string `json:"qrcode"` //二维码图片，data URL
+}
+
+// 获取要操作的用户：未指定id时为当前用户，操作其他用户需要管理员权限
+func totpUser(c *gin.Context) (*db.User, error) {
+	user := currentUser(c)
+	if user == nil {
+		return nil, errors.New("用户不存在")
+	}
+
+	strID := c.Query("id")
+	if len(strID) == 0 {
+		return user, nil
+	}
+
+	id, err := strconv.Atoi(strID)
+	if err != nil {
+		return nil, errors.New("参数错误")
+	}
+
+	if uint(id) == user.ID {
+		return user, nil
+	}
+
+	if !isAdmin(c) {
+		return nil, errors.New("权限不足")
+	}
+
+	target := &db.User{}
+	result := db.DBOperObj().GetDB().First(target, id)
+	if result.Error != nil {
+		return nil, errors.New("用户不存在")
+	}
+
+	return target, nil
+}
+
+// 已启用动态密码的用户修改动态密码前，需要提供当前的验证码或恢复码(参数current)
+func verifyCurrentCode(c *gin.Context) error {
+	user := currentUser(c)
+	if user == nil {
+		return errors.New("用户不存在")
+	}
+
+	if len(user.Secret) == 0 {
+		return nil
+	}
+
+	code := c.Query("current")
+	return guardCode(c, user.Name, func() bool {
+		return totp.Validate(code, user.Secret) || db.UseRecoveryCode(user.Name, code)
+	})
+}
+
+// 校验验证码，失败次数计入登录限制，防止会话被盗用后暴力猜测
+func guardCode(c *gin.Context, name string, validate func() bool) error {
+	ip := c.ClientIP()
+	guard := LoginGuardObj()
+
+	ok, wait := guard.Check(ip, name)
+	if !ok {
+		return fmt.Errorf("尝试次数过多，请 %d 秒后重试", int(wait.Seconds())+1)
+	}
+
+	if validate() {
+		guard.Success(ip, name)
+		return nil
+	}
+
+	guard.Fail(ip, name)
+	auditLog(c, "用户", "验证码错误：%s, IP:%s", name, ip)
+
+	return errors.New("验证码错误")
+}
+
+// 生成用户的动态密码
+func genDynamicPassword(name string) (*DynamicPassword, error) {
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      totpIssuer,
+		AccountName: name,
+		Algorithm:   otp.AlgorithmSHA512,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	pwd := &DynamicPassword{}
+
+	//不能使用key.URL，手机动态识别的用户信息错误
+	pwd.AuthURL = fmt.Sprintf("otpauth://totp/%s?secret=%s&issuer=%s", url.PathEscape(key.AccountName()), key.Secret(), key.Issuer())
+	pwd.Secret = key.Secret()
+
+	return pwd, nil
+}
+
+// 命令行重置用户的身份验证，返回新的动态密码
+func ResetAuth(name string) (*DynamicPassword, error) {
+	pwd, err := genDynamicPassword(name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.ResetAuth(name, pwd.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return pwd, nil
+}
+
+// 生成动态密码的二维码，返回data URL
+func totpQRCode(authURL string) (string, error) {
+	key, err := otp.NewKeyFromURL(authURL)
+	if err != nil {
+		return "", err
+	}
+
+	img, err := key.Image(qrCodeSize, qrCodeSize)
+	if err != nil {
+		return "", err
+	}
+
+	buf := bytes.Buffer{}
+	err = png.Encode(&buf, img)
+	if err != nil {
+		return "", err
+	}
+
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// 开始绑定动态密码：生成待确认的密钥和二维码，确认前原有密钥继续有效；已启用时需要当前验证码
+func (u *UserApi) enroll(c *gin.Context) {
+	user, err := totpUser(c)
+	if err == nil {
+		err = verifyCurrentCode(c)
+	}
+
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": err.Error(),
+		})
+		return
+	}
+
+	pwd, err := genDynamicPassword(user.Name)
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": err.Error(),
+		})
+		return
+	}
+
+	pwd.QRCode, err = totpQRCode(pwd.AuthURL)
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": err.Error(),
+		})
+		return
+	}
+
+	if user.ID == currentUser(c).ID {
+		err = db.DBOperObj().GetDB().Model(user).Update("pending_secret", pwd.Secret).Error
+	} else {
+		//管理员重置其他用户：原动态密码立即失效，用户使用新密码登录后启用
+		err = db.DBOperObj().GetDB().Transaction(func(tx *gorm.DB) error {
+			return db.ResetSecret(tx, user, "", pwd.Secret)
+		})
+	}
+
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": err.Error(),
+		})
+		return
+	}
+
+	auditLog(c, "用户", "生成待确认的动态密码：%s", user.Name)
+
+	c.JSON(200, gin.H{
+		"err":   "",
+		"infos": pwd,
+	})
+}
+
+// 确认绑定动态密码：验证码正确后启用新密钥，生成恢复码并注销其他会话
+// 待确认密钥只能在校验当前验证码后生成，这里不再重复校验，避免恢复码被提前用掉
+func (u *UserApi) confirm(c *gin.Context) {
+	user, err := totpUser(c)
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": err.Error(),
+		})
+		return
+	}
+
+	if len(user.PendingSecret) == 0 {
+		c.JSON(200, gin.H{
+			"err": "请先生成动态密码",
+		})
+		return
+	}
+
+	code := c.Query("code")
+	err = guardCode(c, user.Name, func() bool {
+		return totp.Validate(code, user.PendingSecret)
+	})
+
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": err.Error(),
+		})
+		return
+	}
+
+	var codes []string
+	err = db.DBOperObj().GetDB().Transaction(func(tx *gorm.DB) error {
+		err := db.ActivateSecret(tx, user)
+		if err != nil {
+			return err
+		}
+
+		codes, err = db.GenRecoveryCodes(tx, user.Name)
+		if err != nil {
+			return err
+		}
+
+		return db.DeleteUserSessions(tx, user.Name, currentSessionID(c))
+	})
+
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": err.Error(),
+		})
+		return
+	}
+
+	auditLog(c, "用户", "启用动态密码：%s", user.Name)
+
+	c.JSON(200, gin.H{
+		"err":   "",
+		"infos": codes,
+	})
+}
+
+// 重新生成当前用户的恢复码，需要验证码确认
+func (u *UserApi) recoveryCodes(c *gin.Context) {
+	user := currentUser(c)
+	if user == nil || len(user.Secret) == 0 {
+		c.JSON(200, gin.H{
+			"err": "未启用动态密码",
+		})
+		return
+	}
+
+	code := c.Query("code")
+	err := guardCode(c, user.Name, func() bool {
+		return totp.Validate(code, user.Secret)
+	})
+
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": err.Error(),
+		})
+		return
+	}
+
+	codes, err := db.GenRecoveryCodes(db.DBOperObj().GetDB(), user.Name)
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": err.Error(),
+		})
+		return
+	}
+
+	auditLog(c, "用户", "重新生成恢复码：%s", user.Name)
+
+	c.JSON(200, gin.H{
+		"err":   "",
+		"infos": codes,
+	})
+}
@@ -0,0 +1,298 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"wakelan/backend/db"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type UserApi struct {
+}
+
+// 当前登录的用户
+func (u *UserApi) me(c *gin.Context) {
+	c.JSON(200, gin.H{
+		"err":   "",
+		"infos": currentUser(c),
+	})
+}
+
+// 获取用户列表
+func (u *UserApi) list(c *gin.Context) {
+	users := []db.User{}
+	result := db.DBOperObj().GetDB().Order("name").Find(&users)
+	if result.Error != nil {
+		c.JSON(200, gin.H{
+			"err": result.Error.Error(),
+		})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"err":   "",
+		"infos": users,
+	})
+}
+
+// 添加或修改用户，ID为0时添加；至少保留一个启用的管理员
+// 新用户处于待绑定状态，返回动态密码，用户使用该动态密码首次登录后启用
+func (u *UserApi) save(c *gin.Context) {
+	data, _ := c.GetRawData()
+
+	user := &db.User{}
+	err := json.Unmarshal(data, user)
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": "参数错误",
+		})
+		return
+	}
+
+	user.Name = strings.TrimSpace(user.Name)
+	if len(user.Name) == 0 {
+		c.JSON(200, gin.H{
+			"err": "用户名不能为空",
+		})
+		return
+	}
+
+	if !db.IsRole(user.Role) {
+		c.JSON(200, gin.H{
+			"err": "角色错误",
+		})
+		return
+	}
+
+	var pwd *DynamicPassword
+	if user.ID == 0 {
+		pwd, err = genDynamicPassword(user.Name)
+		if err == nil {
+			pwd.QRCode, err = totpQRCode(pwd.AuthURL)
+		}
+
+		if err != nil {
+			c.JSON(200, gin.H{
+				"err": err.Error(),
+			})
+			return
+		}
+
+		user.Secret = ""
+		user.PendingSecret = pwd.Secret
+	}
+
+	dbObj := db.DBOperObj().GetDB()
+	err = dbObj.Transaction(func(tx *gorm.DB) error {
+		var result *gorm.DB
+		if user.ID == 0 {
+			result = tx.Create(user)
+		} else {
+			//会话、恢复码和日志以用户名关联，不允许修改用户名
+			old := &db.User{}
+			result = tx.First(old, user.ID)
+			if result.Error != nil {
+				return errors.New("用户不存在")
+			}
+
+			if old.Name != user.Name {
+				return errors.New("不能修改用户名")
+			}
+
+			result = tx.Model(user).Select("role", "disabled").Updates(user)
+		}
+
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if db.AdminCount(tx) == 0 {
+			return errors.New("至少保留一个启用的管理员")
+		}
+
+		//禁用的用户立即下线
+		if user.Disabled {
+			return db.DeleteUserSessions(tx, user.Name, "")
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": err.Error(),
+		})
+		return
+	}
+
+	auditLog(c, "用户", "保存用户：%s，角色：%s，禁用：%v", user.Name, user.Role, user.Disabled)
+
+	c.JSON(200, gin.H{
+		"err": "",
+		"infos": gin.H{
+			"id":     user.ID,
+			"enroll": pwd,
+		},
+	})
+}
+
+// 删除用户
+func (u *UserApi) del(c *gin.Context) {
+	id, err := strconv.Atoi(c.Query("id"))
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": "参数错误",
+		})
+		return
+	}
+
+	dbObj := db.DBOperObj().GetDB()
+	err = dbObj.Transaction(func(tx *gorm.DB) error {
+		user := &db.User{}
+		result := tx.First(user, id)
+		if result.Error != nil {
+			return errors.New("用户不存在")
+		}
+
+		result = tx.Unscoped().Delete(user)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if db.AdminCount(tx) == 0 {
+			return errors.New("至少保留一个启用的管理员")
+		}
+
+		return db.DeleteUserSessions(tx, user.Name, "")
+	})
+
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": err.Error(),
+		})
+		return
+	}
+
+	auditLog(c, "用户", "删除用户：%d", id)
+
+	c.JSON(200, gin.H{
+		"err": "",
+	})
+}
+
+// 退出登录，删除当前会话
+func (u *UserApi) logout(c *gin.Context) {
+	id := currentSessionID(c)
+	if len(id) != 0 {
+		db.DeleteSession(id, "")
+	}
+
+	auditLog(c, "登录", "退出登录")
+
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     "token",
+		Value:    "",
+		Path:     "/api",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	c.JSON(200, gin.H{
+		"err": "",
+	})
+}
+
+// 获取会话列表，管理员all=1时返回所有用户的会话
+func (u *UserApi) sessions(c *gin.Context) {
+	user := currentUserName(c)
+	if c.Query("all") == "1" && isAdmin(c) {
+		user = ""
+	}
+
+	sessions, err := db.GetSessions(user)
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": err.Error(),
+		})
+		return
+	}
+
+	current := currentSessionID(c)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == current
+	}
+
+	c.JSON(200, gin.H{
+		"err":   "",
+		"infos": sessions,
+	})
+}
+
+// 注销指定会话，非管理员只能注销自己的会话
+func (u *UserApi) revokeSession(c *gin.Context) {
+	id := c.Query("id")
+	if len(id) == 0 {
+		c.JSON(200, gin.H{
+			"err": "参数错误",
+		})
+		return
+	}
+
+	user := currentUserName(c)
+	if isAdmin(c) {
+		user = ""
+	}
+
+	count, err := db.DeleteSession(id, user)
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": err.Error(),
+		})
+		return
+	}
+
+	if count == 0 {
+		c.JSON(200, gin.H{
+			"err": "会话不存在",
+		})
+		return
+	}
+
+	auditLog(c, "登录", "注销会话：%s", id)
+
+	c.JSON(200, gin.H{
+		"err": "",
+	})
+}
+
+// 注销当前会话以外的所有会话，管理员all=1时注销所有用户的会话
+func (u *UserApi) revokeAllSessions(c *gin.Context) {
+	user := currentUserName(c)
+	if c.Query("all") == "1" && isAdmin(c) {
+		user = ""
+	}
+
+	err := db.DeleteUserSessions(db.DBOperObj().GetDB(), user, currentSessionID(c))
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": err.Error(),
+		})
+		return
+	}
+
+	if len(user) == 0 {
+		auditLog(c, "登录", "注销所有用户的其他会话")
+	} else {
+		auditLog(c, "登录", "注销其他会话")
+	}
+
+	c.JSON(200, gin.H{
+		"err": "",
+	})
+}
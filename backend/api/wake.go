@@ -1,14 +1,17 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"net"
 	"net/http"
-	"sort"
+	"strconv"
+	"strings"
 	"sync"
-	"wakelan/backend/comm"
+	"time"
 	"wakelan/backend/db"
 	"wakelan/backend/network"
+	"wakelan/backend/power"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
@@ -65,7 +68,28 @@ func (w *WakeApi) getInterfaces(c *gin.Context) {
 	})
 }
 
-// 打开网络
+// 保存打开的网卡
+func (w *WakeApi) saveNetCards() {
+	ifs := []InterfaceInfo{}
+	for _, card := range network.NetProtoObj().GetCards() {
+		ifs = append(ifs, InterfaceInfo{
+			Name: card.Name,
+			Desc: card.Desc,
+			IPS:  card.IPS,
+		})
+	}
+
+	info := &db.GlobalInfo{}
+	dbObj := db.DBOperObj().GetDB()
+	dbObj.Find(info)
+
+	data, _ := json.Marshal(ifs)
+	info.NetCard = string(data)
+
+	dbObj.Select("netcard").Save(info)
+}
+
+// 打开网络，add为1时保留已打开的网卡
 func (w *WakeApi) openCard(c *gin.Context) {
 	iface, err := network.NetProtoObj().GetInterfaceByName(c.Query("name"))
 	if err != nil {
@@ -75,7 +99,10 @@ func (w *WakeApi) openCard(c *gin.Context) {
 		return
 	}
 
-	network.NetProtoObj().Close()
+	if c.Query("add") != "1" {
+		network.NetProtoObj().Close()
+	}
+
 	err = network.NetProtoObj().Open(iface, true)
 	if err != nil {
 		c.JSON(200, gin.H{
@@ -84,28 +111,39 @@ func (w *WakeApi) openCard(c *gin.Context) {
 		return
 	}
 
-	info := &db.GlobalInfo{}
-	dbObj := db.DBOperObj().GetDB()
-	dbObj.Find(info)
+	w.saveNetCards()
 
-	i := InterfaceInfo{}
-	i.Name = iface.Name
-	i.Desc = iface.Description
+	c.JSON(200, gin.H{
+		"err": "",
+	})
+}
 
-	for _, addr := range iface.Addresses {
-		i.IPS = append(i.IPS, addr.IP.String())
+// 关闭网卡
+func (w *WakeApi) closeCard(c *gin.Context) {
+	name := c.Query("name")
+	if len(name) == 0 {
+		c.JSON(200, gin.H{
+			"err": "网卡不能为空",
+		})
+		return
 	}
 
-	data, _ := json.Marshal(i)
-	info.NetCard = string(data)
-
-	dbObj.Select("netcard").Save(info)
+	network.NetProtoObj().CloseCard(name)
+	w.saveNetCards()
 
 	c.JSON(200, gin.H{
 		"err": "",
 	})
 }
 
+// 获取打开的网卡
+func (w *WakeApi) getOpenCards(c *gin.Context) {
+	c.JSON(200, gin.H{
+		"err":   "",
+		"infos": network.NetProtoObj().GetCards(),
+	})
+}
+
 // 探测网络
 func (w *WakeApi) probeNetwork(c *gin.Context) {
 	obj := network.NetProtoObj()
@@ -116,12 +154,31 @@ func (w *WakeApi) probeNetwork(c *gin.Context) {
 		return
 	}
 
-	db.DBLog("探测网络", "网卡：%s", obj.GetLocalInfo().Name)
+	names := []string{}
+	for _, iface := range obj.GetLocalInfos() {
+		names = append(names, iface.Name)
+	}
+
+	auditLog(c, "探测网络", "网卡：%s", strings.Join(names, ","))
+
+	//已有扫描任务时返回该任务
+	id := network.ScanJobMG().Running()
+	if len(id) == 0 {
+		rate, _ := strconv.Atoi(c.Query("rate"))
 
-	obj.QueryNet(6)
+		var err error
+		id, err = network.ScanJobMG().Start(c.Query("cidr"), rate)
+		if err != nil {
+			c.JSON(200, gin.H{
+				"err": err.Error(),
+			})
+			return
+		}
+	}
 
 	c.JSON(200, gin.H{
-		"err": "",
+		"err":   "",
+		"infos": id,
 	})
 }
 
@@ -154,6 +211,7 @@ func (w *WakeApi) delNetworklist(c *gin.Context) {
 func (w *WakeApi) addNetworklist(c *gin.Context) {
 	info := &db.MacInfo{}
 	info.IP = c.Query("ip")
+	info.IPNum = db.IPNum(info.IP)
 	info.Mac = c.Query("mac")
 	info.AttachInfo.Mac = info.Mac
 	info.AttachInfo.Describe = c.Query("describe")
@@ -180,98 +238,145 @@ func (w *WakeApi) addNetworklist(c *gin.Context) {
 	})
 }
 
-// 获取网络列表
-func (w *WakeApi) getNetworklist(c *gin.Context) {
-	isAes := c.DefaultQuery("aes", "1")
-	infos := []db.MacInfo{}
+// 保存已发现的机器，未获取到的字段保留已保存的值
+func saveDiscovered() {
 	datas := network.NetProtoObj().GetResult()
+	dbObj := db.DBOperObj().GetDB()
 
 	for _, info := range datas {
 		macInfo := db.MacInfo{}
-		macInfo.IP = info.IP.String()
+		if info.IP != nil {
+			macInfo.IP = info.IP.String()
+			macInfo.IPNum = db.IPNum(macInfo.IP)
+		}
+
+		ip6s := []string{}
+		for _, ip := range info.IPv6 {
+			ip6s = append(ip6s, ip.String())
+		}
+
+		macInfo.IPv6 = strings.Join(ip6s, ",")
 		macInfo.Mac = info.Mac.String()
 		macInfo.MANUF = info.MANUF
+		macInfo.Hostname = info.Hostname
+		macInfo.Iface = info.Iface
+		macInfo.VLAN = info.VLAN
 
-		infos = append(infos, macInfo)
-	}
+		omits := []string{}
+		if len(macInfo.IP) == 0 {
+			omits = append(omits, "ip", "ip_num")
+		}
 
-	dbObj := db.DBOperObj().GetDB()
+		if len(macInfo.IPv6) == 0 && !info.IPv6Learned {
+			omits = append(omits, "ipv6")
+		}
 
-	if len(infos) != 0 {
-		dbObj.Save(infos)
-	}
+		if len(macInfo.Hostname) == 0 {
+			omits = append(omits, "hostname")
+		}
 
-	//获取加星
-	infos = []db.MacInfo{}
+		dbObj.Omit(omits...).Save(&macInfo)
+	}
+}
 
-	//注意：AttachInfo是结构字段的名称，不是表名
-	result := dbObj.Joins("AttachInfo").Find(&infos, "AttachInfo.star==1")
+// 获取网络列表
+func (w *WakeApi) getNetworklist(c *gin.Context) {
+	isAes := c.DefaultQuery("aes", "1")
 
-	sort.Slice(infos, func(i, j int) bool {
-		ip1 := net.ParseIP(infos[i].IP)
-		ip2 := net.ParseIP(infos[j].IP)
-		if isAes == "1" {
-			return comm.IpLess(ip1, ip2)
-		}
+	saveDiscovered()
 
-		return !comm.IpLess(ip1, ip2)
+	infos, _, err := db.ListDevices(db.DeviceQuery{
+		Sort:      "ip",
+		Desc:      isAes != "1",
+		StarFirst: true,
 	})
 
-	if result.Error != nil {
+	if err != nil {
 		c.JSON(200, gin.H{
-			"err": result.Error.Error(),
+			"err": err.Error(),
 		})
 		return
 	}
 
-	var tmpInfo = []db.MacInfo{}
+	c.JSON(200, gin.H{
+		"err":   "",
+		"infos": infos,
+	})
+}
 
-	result = dbObj.Joins("AttachInfo").
-		Where("AttachInfo.star is null or AttachInfo.star=0").Find(&tmpInfo)
+// 唤醒
+func (w *WakeApi) wakeLan(c *gin.Context) {
+	mac := c.Query("mac")
+	if len(mac) == 0 {
+		c.JSON(200, gin.H{
+			"err": "MAC不能为空",
+		})
+		return
+	}
+
+	//未指定模式时使用机器保存的模式
+	param, err := network.LoadWakeParam(mac)
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": err.Error(),
+		})
+		return
+	}
 
-	sort.Slice(tmpInfo, func(i, j int) bool {
-		ip1 := net.ParseIP(tmpInfo[i].IP)
-		ip2 := net.ParseIP(tmpInfo[j].IP)
-		if isAes == "1" {
-			return comm.IpLess(ip1, ip2)
+	if strMode, ok := c.GetQuery("mode"); ok {
+		param.Mode, err = network.ParseWakeMode(strMode)
+		if err != nil {
+			c.JSON(200, gin.H{
+				"err": err.Error(),
+			})
+			return
 		}
 
-		return !comm.IpLess(ip1, ip2)
-	})
+		param.Target = c.Query("target")
+	}
 
-	if result.Error != nil {
+	err = network.NetProtoObj().WakeLan(mac, param)
+	if err != nil {
 		c.JSON(200, gin.H{
-			"err": result.Error.Error(),
+			"err": err.Error(),
 		})
+
 		return
 	}
 
-	infos = append(infos, tmpInfo...)
+	auditLog(c, "唤醒", "Mac：%s，模式：%s %s，SecureOn：%t", mac, param.Mode, param.Target, len(param.Password) != 0)
 
 	c.JSON(200, gin.H{
-		"err":   "",
-		"infos": infos,
+		"err": "",
 	})
 }
 
-// 唤醒
-func (w *WakeApi) wakeLan(c *gin.Context) {
-	err := comm.WakeLan(c.Query("mac"))
+// 唤醒并验证上线，返回任务ID
+func (w *WakeApi) wakeJob(c *gin.Context) {
+	timeout, _ := strconv.Atoi(c.Query("timeout"))
+	interval, _ := strconv.Atoi(c.Query("interval"))
+
+	id, err := network.WakeJobMG().Start(c.Query("mac"), c.Query("ip"), timeout, interval)
 	if err != nil {
 		c.JSON(200, gin.H{
 			"err": err.Error(),
 		})
-
 		return
 	}
 
-	db.DBLog("唤醒", "Mac：%s", c.Query("mac"))
+	auditLog(c, "唤醒验证", "Mac：%s，任务：%s", c.Query("mac"), id)
 
 	c.JSON(200, gin.H{
-		"err": "",
+		"err":   "",
+		"infos": id,
 	})
 }
 
+// 推送唤醒任务状态
+func (w *WakeApi) wakeJobStatus(c *gin.Context) {
+	pushJobStatus[network.WakeJobInfo](c, network.WakeJobMG(), c.Query("id"))
+}
+
 // 操作星
 func (w *WakeApi) operStar(c *gin.Context) {
 	info := &db.AttachInfo{}
@@ -308,9 +413,57 @@ func (w *WakeApi) operStar(c *gin.Context) {
 	}
 
 	if info.Star {
-		db.DBLog("收藏", "Mac：%s", info.Mac)
+		auditLog(c, "收藏", "Mac：%s", info.Mac)
+	} else {
+		auditLog(c, "取消收藏", "Mac：%s", info.Mac)
+	}
+
+	c.JSON(200, gin.H{
+		"err": "",
+	})
+}
+
+// 设置可信机器，可信机器不产生告警
+func (w *WakeApi) setTrusted(c *gin.Context) {
+	info := &db.AttachInfo{}
+	info.Mac = c.Query("mac")
+	info.Trusted = c.Query("trusted") == "1"
+	dbObj := db.DBOperObj().GetDB()
+
+	if len(info.Mac) == 0 {
+		c.JSON(200, gin.H{
+			"err": "MAC不能为空",
+		})
+		return
+	}
+
+	result := dbObj.Model(info).Update("trusted", info.Trusted)
+	if result.Error != nil {
+		c.JSON(200, gin.H{
+			"err": result.Error.Error(),
+		})
+
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		result = dbObj.Save(info)
+	}
+
+	if result.Error != nil {
+		c.JSON(200, gin.H{
+			"err": result.Error.Error(),
+		})
+
+		return
+	}
+
+	network.AlertObj().SetTrusted(info.Mac, info.Trusted)
+
+	if info.Trusted {
+		auditLog(c, "可信机器", "Mac：%s", info.Mac)
 	} else {
-		db.DBLog("取消收藏", "Mac：%s", info.Mac)
+		auditLog(c, "取消可信", "Mac：%s", info.Mac)
 	}
 
 	c.JSON(200, gin.H{
@@ -353,13 +506,297 @@ func (w *WakeApi) editPCInfo(c *gin.Context) {
 		return
 	}
 
-	db.DBLog("编辑机器信息", "Mac：%s，描述：%s", info.Mac, info.Describe)
+	auditLog(c, "编辑机器信息", "Mac：%s，描述：%s", info.Mac, info.Describe)
+
+	c.JSON(200, gin.H{
+		"err": "",
+	})
+}
+
+// 设置唤醒模式
+func (w *WakeApi) setWakeMode(c *gin.Context) {
+	info := &db.AttachInfo{}
+	info.Mac = c.Query("mac")
+	info.WakeTarget = c.Query("target")
+	dbObj := db.DBOperObj().GetDB()
+
+	if len(info.Mac) == 0 {
+		c.JSON(200, gin.H{
+			"err": "MAC不能为空",
+		})
+		return
+	}
+
+	mode, err := network.ParseWakeMode(c.Query("mode"))
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": err.Error(),
+		})
+		return
+	}
+
+	info.WakeMode = int(mode)
+
+	if mode == network.WakeUnicast && len(info.WakeTarget) == 0 {
+		c.JSON(200, gin.H{
+			"err": "单播地址不能为空",
+		})
+		return
+	}
+
+	if mode == network.WakeRawEther && len(info.WakeTarget) != 0 {
+		_, err = net.ParseMAC(info.WakeTarget)
+		if err != nil {
+			c.JSON(200, gin.H{
+				"err": err.Error(),
+			})
+			return
+		}
+	}
+
+	result := dbObj.Model(info).Updates(map[string]interface{}{
+		"wake_mode":   info.WakeMode,
+		"wake_target": info.WakeTarget,
+	})
+	if result.Error != nil {
+		c.JSON(200, gin.H{
+			"err": result.Error.Error(),
+		})
+
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		result = dbObj.Save(info)
+	}
+
+	if result.Error != nil {
+		c.JSON(200, gin.H{
+			"err": result.Error.Error(),
+		})
+
+		return
+	}
+
+	auditLog(c, "唤醒模式", "Mac：%s，模式：%s %s", info.Mac, mode, info.WakeTarget)
+
+	c.JSON(200, gin.H{
+		"err": "",
+	})
+}
+
+// 设置SecureOn密码，pwd为前端加密后的数据，为空时清除
+func (w *WakeApi) setSecureOn(c *gin.Context) {
+	info := &db.AttachInfo{}
+	info.Mac = c.Query("mac")
+	info.SecureOn = c.Query("pwd")
+	dbObj := db.DBOperObj().GetDB()
+
+	if len(info.Mac) == 0 {
+		c.JSON(200, gin.H{
+			"err": "MAC不能为空",
+		})
+		return
+	}
+
+	_, err := network.DecryptSecureOn(info.SecureOn)
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": "SecureOn密码格式错误，需为4字节(1.2.3.4)或6字节(01:02:03:04:05:06)",
+		})
+		return
+	}
+
+	result := dbObj.Model(info).Update("secure_on", info.SecureOn)
+	if result.Error != nil {
+		c.JSON(200, gin.H{
+			"err": result.Error.Error(),
+		})
+
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		result = dbObj.Save(info)
+	}
+
+	if result.Error != nil {
+		c.JSON(200, gin.H{
+			"err": result.Error.Error(),
+		})
+
+		return
+	}
+
+	auditLog(c, "SecureOn密码", "Mac：%s，设置：%t", info.Mac, len(info.SecureOn) != 0)
+
+	c.JSON(200, gin.H{
+		"err": "",
+	})
+}
+
+// 设置电源操作配置
+func (w *WakeApi) setPower(c *gin.Context) {
+	data, _ := c.GetRawData()
+
+	info := &db.AttachInfo{}
+	info.Mac = c.Query("mac")
+	info.Power = string(data)
+	dbObj := db.DBOperObj().GetDB()
+
+	if len(info.Mac) == 0 {
+		c.JSON(200, gin.H{
+			"err": "MAC不能为空",
+		})
+		return
+	}
+
+	//为空时清除配置
+	if len(info.Power) != 0 {
+		powerInfo := power.PowerInfo{}
+		err := json.Unmarshal(data, &powerInfo)
+		if err != nil {
+			c.JSON(200, gin.H{
+				"err": "参数错误",
+			})
+			return
+		}
+
+		err = powerInfo.Check()
+		if err != nil {
+			c.JSON(200, gin.H{
+				"err": err.Error(),
+			})
+			return
+		}
+
+		//未指定主机公钥且主机不变时，保留已记录的指纹
+		if len(powerInfo.HostKey) == 0 {
+			old := &db.AttachInfo{}
+			dbObj.Where("mac=?", info.Mac).Find(old)
+
+			oldInfo := power.PowerInfo{}
+			if json.Unmarshal([]byte(old.Power), &oldInfo) == nil && len(oldInfo.HostKey) != 0 &&
+				oldInfo.Host == powerInfo.Host && oldInfo.Port == powerInfo.Port {
+				powerInfo.HostKey = oldInfo.HostKey
+
+				datas := map[string]interface{}{}
+				json.Unmarshal(data, &datas)
+				datas["host_key"] = oldInfo.HostKey
+				data, _ = json.Marshal(datas)
+				info.Power = string(data)
+			}
+		}
+	}
+
+	result := dbObj.Model(info).Update("power", info.Power)
+	if result.Error != nil {
+		c.JSON(200, gin.H{
+			"err": result.Error.Error(),
+		})
+
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		result = dbObj.Save(info)
+	}
+
+	if result.Error != nil {
+		c.JSON(200, gin.H{
+			"err": result.Error.Error(),
+		})
+
+		return
+	}
+
+	auditLog(c, "电源配置", "Mac：%s", info.Mac)
+
+	c.JSON(200, gin.H{
+		"err": "",
+	})
+}
+
+// 关机、重启、睡眠
+func (w *WakeApi) powerPC(c *gin.Context) {
+	mac := c.Query("mac")
+	if len(mac) == 0 {
+		c.JSON(200, gin.H{
+			"err": "MAC不能为空",
+		})
+		return
+	}
+
+	action := c.Query("action")
+	auditLog(c, "电源", "Mac：%s，动作：%s", mac, action)
+
+	err := power.Run(mac, action)
+	if err != nil {
+		c.JSON(200, gin.H{
+			"err": err.Error(),
+		})
+		return
+	}
 
 	c.JSON(200, gin.H{
 		"err": "",
 	})
 }
 
+// 生成唤醒中继密钥，clear=1时清除
+func (w *WakeApi) genRelayKey(c *gin.Context) {
+	info := &db.AttachInfo{}
+	info.Mac = c.Query("mac")
+	dbObj := db.DBOperObj().GetDB()
+
+	if len(info.Mac) == 0 {
+		c.JSON(200, gin.H{
+			"err": "MAC不能为空",
+		})
+		return
+	}
+
+	if c.Query("clear") != "1" {
+		key, err := network.GenRelayKey()
+		if err != nil {
+			c.JSON(200, gin.H{
+				"err": err.Error(),
+			})
+			return
+		}
+
+		info.RelayKey = key
+	}
+
+	result := dbObj.Model(info).Update("relay_key", info.RelayKey)
+	if result.Error != nil {
+		c.JSON(200, gin.H{
+			"err": result.Error.Error(),
+		})
+
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		result = dbObj.Save(info)
+	}
+
+	if result.Error != nil {
+		c.JSON(200, gin.H{
+			"err": result.Error.Error(),
+		})
+
+		return
+	}
+
+	auditLog(c, "唤醒中继", "Mac：%s，更新中继密钥", info.Mac)
+
+	c.JSON(200, gin.H{
+		"err":   "",
+		"infos": info.RelayKey,
+	})
+}
+
 // 查询当前选择的网卡
 func (w *WakeApi) getSelectNetCard(c *gin.Context) {
 	dbObj := db.DBOperObj().GetDB()
@@ -382,19 +819,34 @@ func (w *WakeApi) getSelectNetCard(c *gin.Context) {
 		return
 	}
 
-	datas := map[string]interface{}{}
+	//多个网卡时返回第一个，兼容只保存一个网卡的旧格式
+	datas := []map[string]interface{}{}
 	err := json.Unmarshal([]byte(info.NetCard), &datas)
 	if err != nil {
+		data := map[string]interface{}{}
+		err = json.Unmarshal([]byte(info.NetCard), &data)
+		if err != nil {
+			c.JSON(200, gin.H{
+				"err": err.Error(),
+			})
+
+			return
+		}
+
+		datas = append(datas, data)
+	}
+
+	if len(datas) == 0 {
 		c.JSON(200, gin.H{
-			"err": result.Error.Error(),
+			"err":   "",
+			"infos": "",
 		})
-
 		return
 	}
 
 	c.JSON(200, gin.H{
 		"err":   "",
-		"infos": datas,
+		"infos": datas[0],
 	})
 }
 
@@ -416,29 +868,52 @@ func (w *WakeApi) pingPC(c *gin.Context) {
 		return
 	}
 
+	writeFun := func(data interface{}) {
+		lock.Lock()
+		conn.WriteJSON(data)
+		lock.Unlock()
+	}
+
+	//连接断开时取消测量任务
+	connCtx, connCancel := context.WithCancel(context.Background())
+
+	//当前的测量任务，新任务开始时取消
+	stopFun := func() {}
+
 	defer func() {
+		connCancel()
+
 		lock.Lock()
 		conn.Close()
 		lock.Unlock()
 	}()
 
 	network.NetProtoObj().AddPingRetFun(conn.RemoteAddr().String(), func(ip, mac string) {
-		lock.Lock()
-		conn.WriteMessage(websocket.TextMessage, []byte(ip+","+mac))
-		lock.Unlock()
+		writeFun(gin.H{
+			"type": "alive",
+			"ip":   ip,
+			"mac":  mac,
+		})
 	})
 
 	defer network.NetProtoObj().DelPingRetFun(conn.RemoteAddr().String())
 
+	//测量会持续发包，只读用户只能检测在线
+	canMeasure := hasRole(c, db.RoleOperator)
+
 	for {
 		_, data, err := conn.ReadMessage()
 		if err != nil {
 			break
 		}
 
+		//ping：检测在线；measure：测量count次；monitor：持续测量，统计最近count次；stop：停止测量
 		type cmd struct {
-			Cmd  string `json:"cmd"`
-			Data string `json:"data"`
+			Cmd      string `json:"cmd"`
+			Data     string `json:"data"`     //IP，多个以逗号分隔，ping为空时检测全部机器
+			Count    int    `json:"count"`    //默认10
+			Interval int    `json:"interval"` //毫秒，默认1000
+			Timeout  int    `json:"timeout"`  //毫秒，默认2000
 		}
 
 		cmdObj := cmd{}
@@ -447,7 +922,8 @@ func (w *WakeApi) pingPC(c *gin.Context) {
 			continue
 		}
 
-		if cmdObj.Cmd == "ping" {
+		switch cmdObj.Cmd {
+		case "ping":
 			ips := []string{}
 
 			if len(cmdObj.Data) == 0 {
@@ -456,7 +932,11 @@ func (w *WakeApi) pingPC(c *gin.Context) {
 				dbObj.Find(&macInfos)
 
 				for _, v := range macInfos {
-					ips = append(ips, v.IP)
+					if len(v.IP) != 0 {
+						ips = append(ips, v.IP)
+					} else if len(v.IPv6) != 0 {
+						ips = append(ips, strings.Split(v.IPv6, ",")...)
+					}
 				}
 			} else {
 				ips = append(ips, cmdObj.Data)
@@ -464,16 +944,61 @@ func (w *WakeApi) pingPC(c *gin.Context) {
 
 			network.NetProtoObj().PingNet(ips)
 
-			iface := network.NetProtoObj().GetLocalInfo()
+			for _, iface := range network.NetProtoObj().GetLocalInfos() {
+				addrs, _ := iface.Addrs()
+				for _, addr := range addrs {
+					writeFun(gin.H{
+						"type": "alive",
+						"ip":   addr.(*net.IPNet).IP.String(),
+						"mac":  iface.HardwareAddr.String(),
+					})
+				}
+			}
+		case "measure", "monitor":
+			if !canMeasure {
+				writeFun(gin.H{
+					"type": "error",
+					"err":  "权限不足",
+				})
+				continue
+			}
 
-			addrs, _ := iface.Addrs()
-			for _, addr := range addrs {
-				data := addr.(*net.IPNet).IP.String() + "," + iface.HardwareAddr.String()
+			stopFun()
+			ctx, cancel := context.WithCancel(connCtx)
+			stopFun = cancel
 
-				lock.Lock()
-				conn.WriteMessage(websocket.TextMessage, []byte(data))
-				lock.Unlock()
+			opt := network.PingOptions{
+				Count:    cmdObj.Count,
+				Interval: time.Duration(cmdObj.Interval) * time.Millisecond,
+				Timeout:  time.Duration(cmdObj.Timeout) * time.Millisecond,
+				Monitor:  cmdObj.Cmd == "monitor",
 			}
+
+			if opt.Count == 0 {
+				opt.Count = 10
+			}
+
+			if opt.Interval == 0 {
+				opt.Interval = time.Second
+			}
+
+			go func(ctx context.Context, ips []string) {
+				err := network.NetProtoObj().Measure(ctx, ips, opt, func(stats network.PingStats) {
+					writeFun(struct {
+						Type string `json:"type"`
+						network.PingStats
+					}{"stats", stats})
+				})
+
+				if err != nil {
+					writeFun(gin.H{
+						"type": "error",
+						"err":  err.Error(),
+					})
+				}
+			}(ctx, strings.Split(cmdObj.Data, ","))
+		case "stop":
+			stopFun()
 		}
 	}
 }
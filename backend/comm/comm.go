@@ -7,6 +7,7 @@ import (
 	"crypto/md5"
 	"crypto/rand"
 	"crypto/tls"
+	"encoding/base64"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -26,6 +27,9 @@ import (
 
 const TimeFormat = "2006-01-02 15:04:05"
 
+// 机器附加信息(远程凭据、SecureOn密码)的加密向量
+const AttachIV = "41FD220EB4878B42"
+
 func inc(ip net.IP) net.IP {
 	tip := make(net.IP, len(ip))
 	copy(tip, ip)
@@ -56,11 +60,40 @@ func Pwd() string {
 	return filepath.Dir(os.Args[0])
 }
 
-// 唤醒机器
-func WakeLan(mac string) error {
+// 解析SecureOn密码，支持4字节(1.2.3.4)和6字节(01:02:03:04:05:06)格式
+func ParseSecureOn(pwd string) ([]byte, error) {
+	pwd = strings.TrimSpace(pwd)
+	if len(pwd) == 0 {
+		return []byte{}, nil
+	}
+
+	if strings.Contains(pwd, ".") {
+		ip := net.ParseIP(pwd).To4()
+		if ip == nil || strings.Count(pwd, ".") != 3 {
+			return []byte{}, errors.New("secureon password format error")
+		}
+
+		return []byte(ip), nil
+	}
+
+	hexStr := strings.NewReplacer(":", "", "-", "").Replace(pwd)
+	data, err := hex.DecodeString(hexStr)
+	if err != nil || (len(data) != 4 && len(data) != 6) {
+		return []byte{}, errors.New("secureon password format error")
+	}
+
+	return data, nil
+}
+
+// 生成魔术包，password为SecureOn密码，可为空
+func MakeMagicPacket(mac string, password []byte) ([]byte, error) {
 	targetMac, err := net.ParseMAC(mac)
 	if err != nil {
-		return err
+		return []byte{}, err
+	}
+
+	if len(targetMac) != 6 {
+		return []byte{}, errors.New("mac address length error")
 	}
 
 	buf := bytes.NewBuffer(nil)
@@ -72,6 +105,24 @@ func WakeLan(mac string) error {
 		buf.Write(targetMac)
 	}
 
+	if len(password) != 0 {
+		if len(password) != 4 && len(password) != 6 {
+			return []byte{}, errors.New("secureon password length error")
+		}
+
+		buf.Write(password)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// 唤醒机器
+func WakeLan(mac string, password []byte) error {
+	pkg, err := MakeMagicPacket(mac, password)
+	if err != nil {
+		return err
+	}
+
 	sendFun := func(port int) error {
 		conn, err := net.Dial("udp", fmt.Sprintf("255.255.255.255:%d", port))
 		if err != nil {
@@ -80,7 +131,7 @@ func WakeLan(mac string) error {
 
 		defer conn.Close()
 
-		_, err = conn.Write(buf.Bytes())
+		_, err = conn.Write(pkg)
 
 		return err
 	}
@@ -257,6 +308,43 @@ func AES_CBC_Open(data []byte, key []byte, iv []byte) ([]byte, error) {
 	return dst, nil
 }
 
+// 加密并使用base64url编码，与前端AESEncrypt一致
+func AES_CBC_SealBase64(data string, key []byte, iv []byte) (string, error) {
+	encData, err := AES_CBC_Seal([]byte(data), key, iv, Zero)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(encData), nil
+}
+
+// 解密base64编码的数据，去除末尾填充
+func AES_CBC_OpenBase64(encData string, key []byte, iv []byte) (string, error) {
+	for len(encData)%4 != 0 {
+		encData += "="
+	}
+
+	data, err := base64.URLEncoding.DecodeString(encData)
+	if err != nil {
+		return "", err
+	}
+
+	if len(data)%aes.BlockSize != 0 {
+		return "", errors.New("data is not a multiple of the block size")
+	}
+
+	data, err = AES_CBC_Open(data, key, iv)
+	if err != nil {
+		return "", err
+	}
+
+	if len(data) == 0 {
+		return "", nil
+	}
+
+	return strings.TrimRight(string(data), "\x00"), nil
+}
+
 type NetInfo struct {
 	IP  []string
 	MAC string
@@ -1,82 +1,297 @@
 package comm
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"strings"
+	"sync"
 	"time"
 )
 
+// Token格式：版本.密钥ID.base64url(nonce+密文)，使用AES-256-GCM加密并认证
+const tokenVersion = "v2"
+
+// Token携带的声明
 type TokenInfo struct {
-	Deadline int64
+	Subject  string `json:"sub"`           //主体，登录Token为用户名
+	Scope    string `json:"scope"`         //用途，不同用途的Token不能混用
+	IssuedAt int64  `json:"iat"`           //签发时间
+	Expire   int64  `json:"exp"`           //过期时间
+	KeyID    string `json:"kid"`           //签发密钥ID
+	Session  string `json:"sid,omitempty"` //会话ID
+}
+
+// Token密钥，Retired为零值表示当前使用的密钥
+type TokenKey struct {
+	ID      string
+	Key     []byte
+	Created time.Time
+	Retired time.Time
+}
+
+type tokenKey struct {
+	TokenKey
+	aead cipher.AEAD
 }
 
 type TokenManager struct {
-	key []byte
-	iv  []byte
+	lock  sync.RWMutex
+	scope string
+	grace time.Duration //密钥轮换后旧密钥继续有效的时间
+	keys  []*tokenKey   //第一个为当前密钥
+}
+
+// 初始化，keys为已保存的密钥，没有可用密钥时生成新密钥
+func (tm *TokenManager) Init(scope string, grace time.Duration, keys ...TokenKey) error {
+	tm.lock.Lock()
+	defer tm.lock.Unlock()
+
+	tm.scope = scope
+	tm.grace = grace
+	tm.keys = nil
+
+	var current *tokenKey
+	for _, key := range keys {
+		k, err := newTokenKey(key)
+		if err != nil {
+			return err
+		}
+
+		if k.Retired.IsZero() && (current == nil || k.Created.After(current.Created)) {
+			current = k
+		}
+
+		tm.keys = append(tm.keys, k)
+	}
+
+	//只保留一个当前密钥，其余的视为已轮换
+	now := time.Now()
+	for _, k := range tm.keys {
+		if k != current && k.Retired.IsZero() {
+			k.Retired = now
+		}
+	}
+
+	tm.prune(now)
+
+	if current == nil {
+		return tm.rotate(now)
+	}
+
+	tm.sortKeys(current)
+
+	return nil
 }
 
-func (tm *TokenManager) Init(key []byte, iv []byte) bool {
-	if len(iv) < 16 {
-		return false
+func newTokenKey(key TokenKey) (*tokenKey, error) {
+	if len(key.Key) != 32 {
+		return nil, errors.New("token key length error")
 	}
 
-	tm.ChangeKey(key)
-	tm.iv = iv[0:16]
+	block, err := aes.NewCipher(key.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
 
-	return false
+	if len(key.ID) == 0 {
+		sum := sha256.Sum256(key.Key)
+		key.ID = hex.EncodeToString(sum[:4])
+	}
+
+	return &tokenKey{key, aead}, nil
 }
 
-func (tm *TokenManager) ChangeKey(key []byte) {
-	if len(key) < 32 {
-		key = append(key, []byte(GenUniqueKey())...)
+// 当前密钥排在最前
+func (tm *TokenManager) sortKeys(current *tokenKey) {
+	keys := []*tokenKey{current}
+	for _, k := range tm.keys {
+		if k != current {
+			keys = append(keys, k)
+		}
 	}
 
-	tm.key = key[0:32]
+	tm.keys = keys
 }
 
-func (tm *TokenManager) GenToken(minute int) (string, error) {
-	info := TokenInfo{time.Now().Add(time.Duration(minute * int(time.Minute))).Unix()}
+// 删除超过宽限期的旧密钥
+func (tm *TokenManager) prune(now time.Time) {
+	keys := []*tokenKey{}
+	for _, k := range tm.keys {
+		if k.Retired.IsZero() || now.Sub(k.Retired) < tm.grace {
+			keys = append(keys, k)
+		}
+	}
+
+	tm.keys = keys
+}
+
+func (tm *TokenManager) rotate(now time.Time) error {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return err
+	}
+
+	k, err := newTokenKey(TokenKey{Key: key, Created: now})
+	if err != nil {
+		return err
+	}
+
+	for _, old := range tm.keys {
+		if old.Retired.IsZero() {
+			old.Retired = now
+		}
+	}
+
+	tm.keys = append(tm.keys, k)
+	tm.prune(now)
+	tm.sortKeys(k)
+
+	return nil
+}
+
+// 轮换密钥：生成新密钥签发Token，旧密钥在宽限期内仍可校验
+func (tm *TokenManager) Rotate() error {
+	tm.lock.Lock()
+	defer tm.lock.Unlock()
+
+	return tm.rotate(time.Now())
+}
+
+// 当前所有有效密钥，用于持久化
+func (tm *TokenManager) Keys() []TokenKey {
+	tm.lock.RLock()
+	defer tm.lock.RUnlock()
+
+	keys := []TokenKey{}
+	for _, k := range tm.keys {
+		keys = append(keys, k.TokenKey)
+	}
+
+	return keys
+}
+
+// 当前密钥的创建时间
+func (tm *TokenManager) KeyCreated() time.Time {
+	tm.lock.RLock()
+	defer tm.lock.RUnlock()
+
+	if len(tm.keys) == 0 {
+		return time.Time{}
+	}
+
+	return tm.keys[0].Created
+}
+
+func (tm *TokenManager) aad(kid string) []byte {
+	return []byte(tokenVersion + "." + kid + "." + tm.scope)
+}
+
+// 签发Token，subject为主体，session为会话ID，expire为有效期
+func (tm *TokenManager) GenToken(subject string, session string, expire time.Duration) (string, error) {
+	tm.lock.RLock()
+	defer tm.lock.RUnlock()
+
+	if len(tm.keys) == 0 {
+		return "", errors.New("token key not init")
+	}
+
+	key := tm.keys[0]
+	now := time.Now()
+
+	info := TokenInfo{
+		Subject:  subject,
+		Scope:    tm.scope,
+		IssuedAt: now.Unix(),
+		Expire:   now.Add(expire).Unix(),
+		KeyID:    key.ID,
+		Session:  session,
+	}
 
 	data, err := json.Marshal(info)
 	if err != nil {
 		return "", err
 	}
 
-	data, err = AES_CBC_Seal(data, tm.key, tm.iv, Zero)
+	nonce := make([]byte, key.aead.NonceSize())
+	_, err = rand.Read(nonce)
 	if err != nil {
 		return "", err
 	}
 
-	return base64.URLEncoding.EncodeToString(data), nil
+	data = key.aead.Seal(nonce, nonce, data, tm.aad(key.ID))
+
+	return tokenVersion + "." + key.ID + "." + base64.RawURLEncoding.EncodeToString(data), nil
 }
 
 func (tm *TokenManager) VerifyToken(token string) bool {
-	for len(token)%4 != 0 {
-		token += "="
+	_, ok := tm.ParseToken(token)
+	return ok
+}
+
+// 校验并解析Token：密钥有效、未被篡改、用途一致且未过期
+func (tm *TokenManager) ParseToken(token string) (*TokenInfo, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != tokenVersion {
+		return nil, false
 	}
 
-	data, err := base64.URLEncoding.DecodeString(token)
+	data, err := base64.RawURLEncoding.DecodeString(parts[2])
 	if err != nil {
-		return false
+		return nil, false
 	}
 
-	data, err = AES_CBC_Open(data, tm.key, tm.iv)
-	if err != nil {
-		return false
+	tm.lock.RLock()
+	defer tm.lock.RUnlock()
+
+	now := time.Now()
+
+	var key *tokenKey
+	for _, k := range tm.keys {
+		if k.ID == parts[1] {
+			key = k
+			break
+		}
 	}
 
-	strData := strings.TrimRight(string(data), "\x00")
+	if key == nil || (!key.Retired.IsZero() && now.Sub(key.Retired) >= tm.grace) {
+		return nil, false
+	}
+
+	size := key.aead.NonceSize()
+	if len(data) < size {
+		return nil, false
+	}
+
+	data, err = key.aead.Open(nil, data[:size], data[size:], tm.aad(key.ID))
+	if err != nil {
+		return nil, false
+	}
 
 	info := TokenInfo{}
-	err = json.Unmarshal([]byte(strData), &info)
+	err = json.Unmarshal(data, &info)
 	if err != nil {
-		return false
+		return nil, false
+	}
+
+	if info.Scope != tm.scope || info.KeyID != key.ID {
+		return nil, false
 	}
 
-	if info.Deadline <= time.Now().Unix() {
-		return false
+	if info.Expire <= now.Unix() || info.IssuedAt < key.Created.Unix() {
+		return nil, false
 	}
 
-	return true
+	return &info, true
 }
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
@@ -20,8 +21,17 @@ import (
 type MacInfo struct {
 	Mac        string     `gorm:"column:mac;primary_key" json:"mac"`
 	IP         string     `gorm:"column:ip" json:"ip"`
+	IPNum      int64      `gorm:"column:ip_num" json:"-"`  //IP的数值，用于排序，与IP同时写入
+	IPv6       string     `gorm:"column:ipv6" json:"ipv6"` //多个地址以逗号分隔
 	MANUF      string     `gorm:"column:manuf" json:"manuf"`
+	Hostname   string     `gorm:"column:hostname" json:"hostname"`
+	Iface      string     `gorm:"column:iface" json:"iface"` //发现该机器的网卡
+	VLAN       int        `gorm:"column:vlan" json:"vlan"`
+	Name       string     `gorm:"-" json:"name"`   //显示名称：自定义名称、描述、主机名
+	Vendor     string     `gorm:"-" json:"vendor"` //显示厂商：自定义厂商优先
 	AttachInfo AttachInfo `gorm:"foreignkey:mac;references:mac" json:"attach_info"`
+	Services   []Service  `gorm:"foreignkey:mac;references:mac" json:"services"`
+	Presence   Presence   `gorm:"foreignkey:mac;references:mac" json:"-"` //首次和最近发现时间
 }
 
 type AttachInfo struct {
@@ -29,6 +39,43 @@ type AttachInfo struct {
 	Star     bool   `gorm:"column:star" json:"star"`
 	Describe string `gorm:"column:describe" json:"describe"`
 	Remote   string `gorm:"column:remote" json:"remote"`
+
+	WakeMode   int    `gorm:"column:wake_mode" json:"wake_mode"`     //0:广播 1:单播/中继 2:以太网帧
+	WakeTarget string `gorm:"column:wake_target" json:"wake_target"` //单播地址或目的MAC
+	SecureOn   string `gorm:"column:secure_on" json:"secure_on"`     //SecureOn密码，前端加密
+	Power      string `gorm:"column:power" json:"power"`             //关机、重启、睡眠配置
+	RelayKey   string `gorm:"column:relay_key" json:"-"`             //唤醒中继HMAC密钥
+	Trusted    bool   `gorm:"column:trusted" json:"trusted"`         //可信机器，不产生告警
+
+	DisplayName    string `gorm:"column:display_name" json:"display_name"`       //自定义显示名称
+	DeviceType     string `gorm:"column:device_type" json:"device_type"`         //设备类型，前端显示图标
+	VendorOverride string `gorm:"column:vendor_override" json:"vendor_override"` //自定义厂商
+	Tags           string `gorm:"column:tags" json:"tags"`                       //多个标签以逗号分隔
+	Notes          string `gorm:"column:notes" json:"notes"`
+}
+
+// 处理json编码
+func (m *MacInfo) MarshalJSON() ([]byte, error) {
+	datas := struct {
+		MacInfo
+		FirstSeen string `json:"first_seen"`
+		LastSeen  string `json:"last_seen"`
+	}{
+		*m,
+		formatTime(m.Presence.FirstSeen),
+		formatTime(m.Presence.LastSeen),
+	}
+
+	return json.Marshal(datas)
+}
+
+// 未记录的时间返回空字符串
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.Format(comm.TimeFormat)
 }
 
 type GlobalInfo struct {
@@ -57,12 +104,25 @@ type GlobalInfo struct {
 	DockerPasswd      string `gorm:"docker_passwd" json:"docker_passwd"`
 
 	CheckIPAddr string `gorm:"column:check_ip_addr;default:http://ddns.oray.com/checkip;https://ipinfo.io/ip;" json:"check_ip_addr"`
+
+	RelayEnable bool `gorm:"column:relay_enable;default:false" json:"relay_enable"`
+	RelayPort   int  `gorm:"column:relay_port;default:9009" json:"relay_port"`
+
+	PresenceInterval int `gorm:"column:presence_interval;default:60" json:"presence_interval"` //在线探测间隔，秒，0为关闭
+
+	ServiceProbe bool   `gorm:"column:service_probe;default:false" json:"service_probe"` //自动探测新发现机器的服务
+	ServicePorts string `gorm:"column:service_ports" json:"service_ports"`               //自定义探测端口，如：8080,9000-9010
+
+	AlertEnable bool `gorm:"column:alert_enable;default:false" json:"alert_enable"` //新机器、MAC变化、IP冲突告警
+
+	SessionIdle int `gorm:"column:session_idle;default:1440" json:"session_idle"` //会话空闲超时，分钟，0为不限制
 }
 
 type Log struct {
 	gorm.Model
-	Cmd string `gorm:"column:cmd" json:"cmd"`
-	Msg string `gorm:"column:msg" json:"msg"`
+	User string `gorm:"column:user" json:"user"` //操作的用户，系统产生的日志为空
+	Cmd  string `gorm:"column:cmd" json:"cmd"`
+	Msg  string `gorm:"column:msg" json:"msg"`
 }
 
 // 处理json编码
@@ -119,6 +179,132 @@ func (m *Message) MarshalJSON() ([]byte, error) {
 	return json.Marshal(datas)
 }
 
+type DeviceGroup struct {
+	gorm.Model
+	Name    string        `gorm:"column:name;unique" json:"name"`
+	Delay   int           `gorm:"column:delay" json:"delay"` //成员唤醒间隔，秒
+	Members []GroupMember `gorm:"foreignKey:GroupID" json:"members"`
+}
+
+type GroupMember struct {
+	GroupID   uint   `gorm:"column:group_id;primaryKey" json:"group_id"`
+	Mac       string `gorm:"column:mac;primaryKey" json:"mac"`
+	SortOrder int    `gorm:"column:sort_order" json:"sort_order"` //唤醒顺序，从小到大
+}
+
+type ScheduleJob struct {
+	gorm.Model
+	Name       string `gorm:"column:name" json:"name"`
+	Cron       string `gorm:"column:cron" json:"cron"`               //分 时 日 月 周
+	Action     string `gorm:"column:action" json:"action"`           //wake:唤醒 shutdown:关机
+	TargetType string `gorm:"column:target_type" json:"target_type"` //device:机器 group:分组
+	Target     string `gorm:"column:target" json:"target"`           //MAC或分组ID
+	Enable     bool   `gorm:"column:enable" json:"enable"`
+	LastRun    string `gorm:"column:last_run" json:"last_run"`
+	LastResult string `gorm:"column:last_result" json:"last_result"`
+}
+
+// 机器在线状态
+type Presence struct {
+	Mac       string    `gorm:"column:mac;primary_key" json:"mac"`
+	Online    bool      `gorm:"column:online" json:"online"`
+	FirstSeen time.Time `gorm:"column:first_seen" json:"-"`
+	LastSeen  time.Time `gorm:"column:last_seen" json:"-"`
+	Changed   time.Time `gorm:"column:changed" json:"-"` //最近一次上下线时间
+}
+
+// 处理json编码
+func (p *Presence) MarshalJSON() ([]byte, error) {
+	datas := struct {
+		Presence
+		FirstSeen string `json:"first_seen"`
+		LastSeen  string `json:"last_seen"`
+		Changed   string `json:"changed"`
+	}{
+		*p,
+		p.FirstSeen.Format(comm.TimeFormat),
+		p.LastSeen.Format(comm.TimeFormat),
+		p.Changed.Format(comm.TimeFormat),
+	}
+
+	return json.Marshal(datas)
+}
+
+// 上下线记录
+type PresenceEvent struct {
+	ID     uint      `gorm:"primarykey" json:"id"`
+	Mac    string    `gorm:"column:mac;index" json:"mac"`
+	Online bool      `gorm:"column:online" json:"online"`
+	Time   time.Time `gorm:"column:time;index" json:"-"`
+}
+
+// 处理json编码
+func (e *PresenceEvent) MarshalJSON() ([]byte, error) {
+	datas := struct {
+		PresenceEvent
+		Time string `json:"time"`
+	}{
+		*e,
+		e.Time.Format(comm.TimeFormat),
+	}
+
+	return json.Marshal(datas)
+}
+
+// 机器开放的服务
+type Service struct {
+	Mac    string    `gorm:"column:mac;primary_key" json:"mac"`
+	Port   int       `gorm:"column:port;primary_key" json:"port"`
+	Name   string    `gorm:"column:name" json:"name"` //ssh、rdp、vnc、telnet、http、https、tcp
+	Banner string    `gorm:"column:banner" json:"banner"`
+	Time   time.Time `gorm:"column:time" json:"-"`
+}
+
+// 处理json编码
+func (s *Service) MarshalJSON() ([]byte, error) {
+	datas := struct {
+		Service
+		Time string `json:"time"`
+	}{
+		*s,
+		s.Time.Format(comm.TimeFormat),
+	}
+
+	return json.Marshal(datas)
+}
+
+// 扫描记录
+type ScanRecord struct {
+	ID       string    `gorm:"column:id;primary_key" json:"id"`
+	CIDR     string    `gorm:"column:cidr" json:"cidr"`
+	Rate     int       `gorm:"column:rate" json:"rate"` //每秒发包数
+	Total    int       `gorm:"column:total" json:"total"`
+	Sent     int       `gorm:"column:sent" json:"sent"`
+	Replies  int       `gorm:"column:replies" json:"replies"`
+	State    string    `gorm:"column:state" json:"state"`
+	Begin    time.Time `gorm:"column:begin;index" json:"-"`
+	End      time.Time `gorm:"column:end" json:"-"`
+	New      int       `gorm:"column:new" json:"new"`
+	Changed  int       `gorm:"column:changed" json:"changed"`
+	Vanished int       `gorm:"column:vanished" json:"vanished"`
+	Detail   string    `gorm:"column:detail" json:"detail"` //新增、变化、消失的机器，json格式
+}
+
+// 处理json编码
+func (s *ScanRecord) MarshalJSON() ([]byte, error) {
+	datas := struct {
+		ScanRecord
+		Begin string `json:"begin"`
+		End   string `json:"end"`
+	}{
+		*s,
+		s.Begin.Format(comm.TimeFormat),
+		s.End.Format(comm.TimeFormat),
+	}
+
+	return json.Marshal(datas)
+}
+
 type DBOper struct {
 	db    *gorm.DB
 	level logger.LogLevel
@@ -161,14 +347,45 @@ func (d *DBOper) Init() error {
 	d.db.Config.Logger = d
 	d.db.Config.Logger.LogMode(logger.Silent)
 
-	db.AutoMigrate(&MacInfo{}, &GlobalInfo{}, &AttachInfo{}, &Log{}, &FileMeta{}, &Message{})
+	db.AutoMigrate(&MacInfo{}, &GlobalInfo{}, &AttachInfo{}, &Log{}, &FileMeta{}, &Message{}, &ScheduleJob{},
+		&DeviceGroup{}, &GroupMember{}, &Presence{}, &PresenceEvent{}, &ScanRecord{}, &Service{}, &User{}, &Session{}, &TokenKey{}, &RecoveryCode{})
 
 	d.SwitchLogger()
 	d.initData(db)
+	d.initUsers(db)
+	d.maskLoginLogs(db)
+	d.fillIPNum(db)
 
 	return nil
 }
 
+// 旧版本没有ip_num，启动时补齐
+func (d *DBOper) fillIPNum(db *gorm.DB) {
+	infos := []MacInfo{}
+	db.Select("mac", "ip").Where("ip<>'' AND (ip_num IS NULL OR ip_num=0)").Find(&infos)
+
+	for _, v := range infos {
+		if num := IPNum(v.IP); num != 0 {
+			db.Model(&v).Update("ip_num", num)
+		}
+	}
+}
+
+// 旧版本登录日志记录了动态密码和Token，启动时脱敏
+func (d *DBOper) maskLoginLogs(db *gorm.DB) {
+	re := regexp.MustCompile(`(key|token):[^,*][^,]*`)
+
+	logs := []Log{}
+	db.Where("cmd=? AND (msg LIKE ? OR msg LIKE ?)", "登录", "%key:%", "%token:%").Find(&logs)
+
+	for _, v := range logs {
+		msg := re.ReplaceAllString(v.Msg, "$1:***")
+		if msg != v.Msg {
+			db.Model(&v).Update("msg", msg)
+		}
+	}
+}
+
 func (d *DBOper) GetDB() *gorm.DB {
 	return d.db
 }
@@ -245,7 +462,13 @@ func DBOperObj() *DBOper {
 }
 
 func DBLog(cmd string, format string, a ...any) {
+	DBUserLog("", cmd, format, a...)
+}
+
+// 记录用户操作
+func DBUserLog(user string, cmd string, format string, a ...any) {
 	info := &Log{}
+	info.User = user
 	info.Cmd = cmd
 	info.Msg = fmt.Sprintf(format, a...)
 	dbObj := DBOperObj().GetDB()
@@ -0,0 +1,196 @@
+package db
+
+import (
+	"net"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// 机器列表查询条件
+type DeviceQuery struct {
+	Keyword    string //搜索IP、MAC、厂商、主机名、名称、描述、标签和备注
+	DeviceType string
+	Tag        string
+	Iface      string
+	Star       string //1：只返回收藏，0：只返回未收藏，空：全部
+	Sort       string //ip、mac、name、vendor、hostname、first_seen、last_seen
+	Desc       bool
+	StarFirst  bool //收藏的机器排在前面
+	Page       int  //从1开始，0表示不分页
+	Size       int
+}
+
+// 排序字段，均在数据库中排序分页，ip按地址数值排序
+var deviceSorts = map[string]string{
+	"ip":         "mac_infos.ip_num",
+	"mac":        "mac_infos.mac",
+	"name":       "lower(coalesce(nullif(AttachInfo.display_name,''),nullif(AttachInfo.describe,''),mac_infos.hostname))",
+	"vendor":     "lower(coalesce(nullif(AttachInfo.vendor_override,''),mac_infos.manuf))",
+	"hostname":   "lower(mac_infos.hostname)",
+	"first_seen": "Presence.first_seen",
+	"last_seen":  "Presence.last_seen",
+}
+
+// 是否支持的排序字段
+func IsDeviceSort(name string) bool {
+	_, ok := deviceSorts[name]
+	return ok
+}
+
+// 查询机器列表，返回当前页和总数
+func ListDevices(q DeviceQuery) ([]MacInfo, int, error) {
+	dbObj := DBOperObj().GetDB()
+
+	//注意：AttachInfo、Presence是结构字段的名称，不是表名
+	tx := dbObj.Model(&MacInfo{}).Joins("AttachInfo").Joins("Presence")
+	if len(q.Keyword) != 0 {
+		like := "%" + escapeLike(strings.ToLower(q.Keyword)) + "%"
+		cols := []string{
+			"mac_infos.ip", "mac_infos.ipv6", "mac_infos.mac", "mac_infos.manuf", "mac_infos.hostname",
+			"AttachInfo.display_name", "AttachInfo.describe", "AttachInfo.vendor_override",
+			"AttachInfo.tags", "AttachInfo.notes",
+		}
+
+		conds := []string{}
+		args := []interface{}{}
+		for _, col := range cols {
+			conds = append(conds, "lower("+col+") like ? escape '\\'")
+			args = append(args, like)
+		}
+
+		tx = tx.Where("("+strings.Join(conds, " or ")+")", args...)
+	}
+
+	if len(q.DeviceType) != 0 {
+		tx = tx.Where("AttachInfo.device_type=?", q.DeviceType)
+	}
+
+	if len(q.Tag) != 0 {
+		tx = tx.Where("(','||replace(AttachInfo.tags,' ','')||',') like ? escape '\\'", "%,"+escapeLike(strings.ReplaceAll(q.Tag, " ", ""))+",%")
+	}
+
+	if len(q.Iface) != 0 {
+		tx = tx.Where("mac_infos.iface=?", q.Iface)
+	}
+
+	switch q.Star {
+	case "1":
+		tx = tx.Where("AttachInfo.star=1")
+	case "0":
+		tx = tx.Where("(AttachInfo.star is null or AttachInfo.star=0)")
+	}
+
+	tx = tx.Session(&gorm.Session{})
+
+	col, ok := deviceSorts[q.Sort]
+	if !ok {
+		col = deviceSorts["ip"]
+	}
+
+	var count int64
+	result := tx.Count(&count)
+	if result.Error != nil {
+		return nil, 0, result.Error
+	}
+
+	order := col
+	if q.Desc {
+		order += " desc"
+	}
+
+	order += ", mac_infos.mac"
+	if q.Desc {
+		order += " desc"
+	}
+
+	if q.StarFirst {
+		order = "coalesce(AttachInfo.star,0) desc, " + order
+	}
+
+	query := tx.Order(order)
+	if q.Page > 0 && q.Size > 0 {
+		query = query.Offset((q.Page - 1) * q.Size).Limit(q.Size)
+	}
+
+	infos := []MacInfo{}
+	result = query.Find(&infos)
+	if result.Error != nil {
+		return nil, 0, result.Error
+	}
+
+	err := loadServices(infos)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for i := range infos {
+		infos[i].Name = infos[i].AttachInfo.DisplayName
+		if len(infos[i].Name) == 0 {
+			infos[i].Name = infos[i].AttachInfo.Describe
+		}
+
+		if len(infos[i].Name) == 0 {
+			infos[i].Name = infos[i].Hostname
+		}
+
+		infos[i].Vendor = infos[i].AttachInfo.VendorOverride
+		if len(infos[i].Vendor) == 0 {
+			infos[i].Vendor = infos[i].MANUF
+		}
+	}
+
+	return infos, int(count), nil
+}
+
+// 转义LIKE中的通配符，配合escape '\'使用
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// IP的排序数值：IPv4为地址的数值，IPv6排在IPv4之后，无效地址为0
+func IPNum(ip string) int64 {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return 0
+	}
+
+	v4 := addr.To4()
+	if v4 == nil {
+		return 1 << 32
+	}
+
+	return int64(v4[0])<<24 | int64(v4[1])<<16 | int64(v4[2])<<8 | int64(v4[3])
+}
+
+// 只加载当前页机器的服务
+func loadServices(infos []MacInfo) error {
+	if len(infos) == 0 {
+		return nil
+	}
+
+	macs := []string{}
+	for _, info := range infos {
+		macs = append(macs, info.Mac)
+	}
+
+	services := []Service{}
+	result := DBOperObj().GetDB().Where("mac in ?", macs).Find(&services)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	index := map[string]int{}
+	for i := range infos {
+		index[infos[i].Mac] = i
+		infos[i].Services = []Service{}
+	}
+
+	for _, service := range services {
+		if i, ok := index[service.Mac]; ok {
+			infos[i].Services = append(infos[i].Services, service)
+		}
+	}
+
+	return nil
+}
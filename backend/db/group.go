@@ -0,0 +1,23 @@
+package db
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// 获取分组及按顺序排列的成员
+func GetGroup(id uint) (*DeviceGroup, error) {
+	dbObj := DBOperObj().GetDB()
+
+	group := &DeviceGroup{}
+	result := dbObj.Preload("Members", func(db *gorm.DB) *gorm.DB {
+		return db.Order("sort_order, mac")
+	}).First(group, id)
+
+	if result.Error != nil {
+		return nil, errors.New("分组不存在")
+	}
+
+	return group, nil
+}
@@ -0,0 +1,155 @@
+package db
+
+import (
+	"crypto/rand"
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	RecoveryCodeCount = 10 //每次生成的恢复码数量
+	recoveryCodeLen   = 10 //恢复码长度，不含分隔符
+	recoveryCodeChars = "abcdefghjkmnpqrstuvwxyz23456789"
+)
+
+// 一次性恢复码，只保存哈希值，使用后删除
+type RecoveryCode struct {
+	ID   uint   `gorm:"column:id;primary_key"`
+	User string `gorm:"column:user;index"`
+	Hash string `gorm:"column:hash"`
+}
+
+// 统一恢复码格式：去除分隔符和空白，转为小写
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+func genRecoveryCode() (string, error) {
+	data := make([]byte, recoveryCodeLen)
+	_, err := rand.Read(data)
+	if err != nil {
+		return "", err
+	}
+
+	for i, v := range data {
+		data[i] = recoveryCodeChars[int(v)%len(recoveryCodeChars)]
+	}
+
+	half := recoveryCodeLen / 2
+	return string(data[:half]) + "-" + string(data[half:]), nil
+}
+
+// 重新生成用户的恢复码，原有恢复码作废，返回明文
+func GenRecoveryCodes(tx *gorm.DB, user string) ([]string, error) {
+	codes := []string{}
+	datas := []RecoveryCode{}
+
+	for i := 0; i < RecoveryCodeCount; i++ {
+		code, err := genRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(normalizeRecoveryCode(code)), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+
+		codes = append(codes, code)
+		datas = append(datas, RecoveryCode{User: user, Hash: string(hash)})
+	}
+
+	result := tx.Where("user=?", user).Delete(&RecoveryCode{})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	result = tx.Create(&datas)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return codes, nil
+}
+
+// 使用恢复码，校验通过后删除
+func UseRecoveryCode(user string, code string) bool {
+	code = normalizeRecoveryCode(code)
+	if len(code) != recoveryCodeLen {
+		return false
+	}
+
+	dbObj := DBOperObj().GetDB()
+
+	datas := []RecoveryCode{}
+	dbObj.Where("user=?", user).Find(&datas)
+
+	for _, data := range datas {
+		if bcrypt.CompareHashAndPassword([]byte(data.Hash), []byte(code)) == nil {
+			result := dbObj.Delete(&data)
+			return result.Error == nil && result.RowsAffected == 1
+		}
+	}
+
+	return false
+}
+
+// 用户剩余的恢复码数量
+func RecoveryCodeLeft(user string) int64 {
+	var count int64
+	DBOperObj().GetDB().Model(&RecoveryCode{}).Where("user=?", user).Count(&count)
+
+	return count
+}
+
+// 更换用户的动态密码：设置新的密钥或待确认密钥，原有恢复码和会话失效
+func ResetSecret(tx *gorm.DB, user *User, secret string, pending string) error {
+	result := tx.Model(user).Select("secret", "pending_secret").Updates(&User{Secret: secret, PendingSecret: pending})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	result = tx.Where("user=?", user.Name).Delete(&RecoveryCode{})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return DeleteUserSessions(tx, user.Name, "")
+}
+
+// 重置用户的身份验证：使用新的动态密码，清除恢复码和会话并启用用户；默认管理员不存在时重新创建
+func ResetAuth(name string, secret string) error {
+	if len(secret) == 0 {
+		return errors.New("动态密码不能为空")
+	}
+
+	return DBOperObj().GetDB().Transaction(func(tx *gorm.DB) error {
+		user := &User{}
+		result := tx.Where("name=?", name).Limit(1).Find(user)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			if name != DefaultUser {
+				return errors.New("用户不存在")
+			}
+
+			user.Name = DefaultUser
+			user.Role = RoleAdmin
+			result = tx.Create(user)
+		} else {
+			result = tx.Model(user).Update("disabled", false)
+		}
+
+		if result.Error != nil {
+			return result.Error
+		}
+
+		return ResetSecret(tx, user, secret, "")
+	})
+}
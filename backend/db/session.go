@@ -0,0 +1,146 @@
+package db
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+	"wakelan/backend/comm"
+
+	"gorm.io/gorm"
+)
+
+const (
+	SessionExpire = 7 * 24 * time.Hour //会话最长有效期
+	sessionTouch  = time.Minute        //最近活动时间的最小更新间隔
+)
+
+// 登录会话
+type Session struct {
+	ID         string    `gorm:"column:id;primary_key" json:"id"`
+	User       string    `gorm:"column:user;index" json:"user"`
+	IP         string    `gorm:"column:ip" json:"ip"`
+	UserAgent  string    `gorm:"column:user_agent" json:"user_agent"`
+	Created    time.Time `gorm:"column:created" json:"-"`
+	LastActive time.Time `gorm:"column:last_active" json:"-"`
+	Expire     time.Time `gorm:"column:expire;index" json:"-"`
+	Current    bool      `gorm:"-" json:"current"` //是否为当前请求的会话
+}
+
+// 处理json编码
+func (s *Session) MarshalJSON() ([]byte, error) {
+	datas := struct {
+		Session
+		Created    string `json:"created"`
+		LastActive string `json:"last_active"`
+		Expire     string `json:"expire"`
+	}{
+		*s,
+		s.Created.Format(comm.TimeFormat),
+		s.LastActive.Format(comm.TimeFormat),
+		s.Expire.Format(comm.TimeFormat),
+	}
+
+	return json.Marshal(datas)
+}
+
+// 创建会话，同时清理过期会话
+func CreateSession(user string, ip string, userAgent string) (*Session, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &Session{
+		ID:         hex.EncodeToString(id),
+		User:       user,
+		IP:         ip,
+		UserAgent:  userAgent,
+		Created:    now,
+		LastActive: now,
+		Expire:     now.Add(SessionExpire),
+	}
+
+	dbObj := DBOperObj().GetDB()
+	dbObj.Where("expire<?", now).Delete(&Session{})
+
+	result := dbObj.Create(session)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return session, nil
+}
+
+// 校验会话：存在、未过期且未超过空闲时间，并更新最近活动时间
+func CheckSession(id string, ip string) (*Session, error) {
+	dbObj := DBOperObj().GetDB()
+
+	session := &Session{}
+	result := dbObj.Where("id=?", id).Limit(1).Find(session)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return nil, errors.New("会话不存在")
+	}
+
+	now := time.Now()
+	idle := time.Duration(DBOperObj().GetConfig().SessionIdle) * time.Minute
+
+	if now.After(session.Expire) || (idle > 0 && now.Sub(session.LastActive) > idle) {
+		dbObj.Delete(session)
+		return nil, errors.New("会话已过期")
+	}
+
+	if now.Sub(session.LastActive) >= sessionTouch || session.IP != ip {
+		session.LastActive = now
+		session.IP = ip
+		dbObj.Model(session).Updates(map[string]interface{}{
+			"last_active": now,
+			"ip":          ip,
+		})
+	}
+
+	return session, nil
+}
+
+// 获取会话，user为空时返回所有用户的会话
+func GetSessions(user string) ([]Session, error) {
+	sessions := []Session{}
+
+	tx := DBOperObj().GetDB().Where("expire>=?", time.Now())
+	if len(user) != 0 {
+		tx = tx.Where("user=?", user)
+	}
+
+	result := tx.Order("last_active desc").Find(&sessions)
+
+	return sessions, result.Error
+}
+
+// 删除会话，user不为空时只删除该用户的会话
+func DeleteSession(id string, user string) (int64, error) {
+	tx := DBOperObj().GetDB().Where("id=?", id)
+	if len(user) != 0 {
+		tx = tx.Where("user=?", user)
+	}
+
+	result := tx.Delete(&Session{})
+
+	return result.RowsAffected, result.Error
+}
+
+// 删除用户的所有会话，except为保留的会话；user为空时删除所有用户的会话
+func DeleteUserSessions(tx *gorm.DB, user string, except string) error {
+	tx = tx.Where("id<>?", except)
+	if len(user) != 0 {
+		tx = tx.Where("user=?", user)
+	}
+
+	return tx.Delete(&Session{}).Error
+}
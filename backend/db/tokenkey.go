@@ -0,0 +1,70 @@
+package db
+
+import (
+	"encoding/hex"
+	"time"
+	"wakelan/backend/comm"
+
+	"gorm.io/gorm"
+)
+
+// Token密钥，Retired为空表示当前使用的密钥
+type TokenKey struct {
+	ID      string    `gorm:"column:id;primary_key"`
+	Scope   string    `gorm:"column:scope;index"`
+	Key     string    `gorm:"column:key"`
+	Created time.Time `gorm:"column:created"`
+	Retired time.Time `gorm:"column:retired"`
+}
+
+// 获取指定用途的Token密钥
+func GetTokenKeys(scope string) ([]comm.TokenKey, error) {
+	datas := []TokenKey{}
+	result := DBOperObj().GetDB().Where("scope=?", scope).Find(&datas)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	keys := []comm.TokenKey{}
+	for _, data := range datas {
+		key, err := hex.DecodeString(data.Key)
+		if err != nil {
+			continue
+		}
+
+		keys = append(keys, comm.TokenKey{
+			ID:      data.ID,
+			Key:     key,
+			Created: data.Created,
+			Retired: data.Retired,
+		})
+	}
+
+	return keys, nil
+}
+
+// 保存指定用途的Token密钥，替换原有密钥
+func SaveTokenKeys(scope string, keys []comm.TokenKey) error {
+	return DBOperObj().GetDB().Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("scope=?", scope).Delete(&TokenKey{})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		for _, key := range keys {
+			result = tx.Create(&TokenKey{
+				ID:      key.ID,
+				Scope:   scope,
+				Key:     hex.EncodeToString(key.Key),
+				Created: key.Created,
+				Retired: key.Retired,
+			})
+
+			if result.Error != nil {
+				return result.Error
+			}
+		}
+
+		return nil
+	})
+}
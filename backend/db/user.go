@@ -0,0 +1,131 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// 用户角色，权限从高到低
+const (
+	RoleAdmin    = "admin"    //全部权限，包括用户和系统配置
+	RoleOperator = "operator" //除用户、系统配置和容器删除外的操作
+	RoleWake     = "wake"     //查看机器并唤醒
+	RoleViewer   = "viewer"   //只读
+)
+
+var roleLevels = map[string]int{
+	RoleViewer:   1,
+	RoleWake:     2,
+	RoleOperator: 3,
+	RoleAdmin:    4,
+}
+
+// 默认管理员，由旧版的共享密钥迁移
+const DefaultUser = "admin"
+
+type User struct {
+	gorm.Model
+	Name     string `gorm:"column:name;unique" json:"name"`
+	Role     string `gorm:"column:role" json:"role"`
+	Secret   string `gorm:"column:secret" json:"-"` //TOTP密钥，为空时无需验证码
+	Disabled bool   `gorm:"column:disabled" json:"disabled"`
+
+	PendingSecret string `gorm:"column:pending_secret" json:"-"` //待确认的TOTP密钥，验证码校验通过后生效
+	TotpEnabled   bool   `gorm:"-" json:"totp_enabled"`
+}
+
+// 处理json编码
+func (u *User) MarshalJSON() ([]byte, error) {
+	type user User
+
+	datas := user(*u)
+	datas.TotpEnabled = len(u.Secret) != 0
+
+	return json.Marshal(datas)
+}
+
+// 初始管理员：由旧版迁移且未设置动态密码，允许免验证码登录以完成绑定
+func (u *User) IsBootstrap() bool {
+	return u.Name == DefaultUser && u.Role == RoleAdmin && len(u.Secret) == 0 && len(u.PendingSecret) == 0
+}
+
+// 启用待确认的动态密码
+func ActivateSecret(tx *gorm.DB, user *User) error {
+	if len(user.PendingSecret) == 0 {
+		return errors.New("没有待确认的动态密码")
+	}
+
+	result := tx.Model(user).Updates(map[string]interface{}{
+		"secret":         user.PendingSecret,
+		"pending_secret": "",
+	})
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	user.Secret = user.PendingSecret
+	user.PendingSecret = ""
+
+	return nil
+}
+
+func IsRole(role string) bool {
+	_, ok := roleLevels[role]
+	return ok
+}
+
+// 角色是否具有required的权限
+func RoleAllow(role string, required string) bool {
+	level, ok := roleLevels[role]
+	if !ok {
+		return false
+	}
+
+	return level >= roleLevels[required]
+}
+
+func GetUser(name string) (*User, error) {
+	user := &User{}
+	result := DBOperObj().GetDB().Where("name=?", name).Limit(1).Find(user)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return nil, errors.New("用户不存在")
+	}
+
+	return user, nil
+}
+
+// 启用的管理员数量
+func AdminCount(tx *gorm.DB) int64 {
+	var count int64
+	tx.Model(&User{}).Where("role=? and disabled=?", RoleAdmin, false).Count(&count)
+
+	return count
+}
+
+// 没有用户时创建默认管理员，使用原有的共享密钥
+func (d *DBOper) initUsers(db *gorm.DB) {
+	var count int64
+	db.Model(&User{}).Count(&count)
+
+	if count != 0 {
+		return
+	}
+
+	user := User{
+		Name:   DefaultUser,
+		Role:   RoleAdmin,
+		Secret: d.GetConfig().Secret,
+	}
+
+	ret := db.Create(&user)
+	if ret.Error != nil {
+		panic(ret.Error.Error())
+	}
+}
@@ -31,7 +31,7 @@ type GuacdCtrl struct {
 }
 
 func (g *GuacdCtrl) Start(wsConn *websocket.Conn, info DstInfo) error {
-	dstConn, err := net.Dial("tcp", fmt.Sprintf("%s:%d", info.GuacdSvr.Host, info.GuacdSvr.Port))
+	dstConn, err := net.Dial("tcp", net.JoinHostPort(info.GuacdSvr.Host, strconv.Itoa(info.GuacdSvr.Port)))
 	if err != nil {
 		return err
 	}
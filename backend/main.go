@@ -1,14 +1,51 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"wakelan/backend/api"
+	"wakelan/backend/db"
 	"wakelan/backend/network"
 )
 
+// 命令行重置身份验证：wakelan resetauth [用户名]
+func resetAuth() {
+	name := db.DefaultUser
+	if len(os.Args) >= 3 {
+		name = os.Args[2]
+	}
+
+	if db.DBOperObj() == nil {
+		fmt.Println("数据库打开失败")
+		os.Exit(1)
+	}
+
+	pwd, err := api.ResetAuth(name)
+	if err != nil {
+		fmt.Println("重置失败：" + err.Error())
+		os.Exit(1)
+	}
+
+	db.DBLog("用户", "命令行重置身份验证：%s", name)
+	fmt.Printf("已重置用户 %s 的动态密码，原有恢复码和会话已失效\n", name)
+	fmt.Printf("密钥：%s\n", pwd.Secret)
+	fmt.Printf("绑定地址：%s\n", pwd.AuthURL)
+	fmt.Println("请使用手机小程序【动态密码】添加后登录，并重新生成恢复码")
+}
+
 func main() {
+	if len(os.Args) >= 2 && os.Args[1] == "resetauth" {
+		resetAuth()
+		return
+	}
+
 	network.NetProtoObj().Init()
+	network.PresenceObj().Start()
+	network.HostResolverObj().Start()
+	network.ServiceProberObj().Start()
+	network.AlertObj().Start()
 	network.PushipOBJ().Start(3 * 60)
+	network.WakeRelayObj().Reload()
 
 	web := api.Web{}
 
@@ -0,0 +1,163 @@
+package network
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"wakelan/backend/db"
+)
+
+// 告警类型
+const (
+	AlertNewDevice  = "new_device"
+	AlertMacChange  = "mac_change"
+	AlertIPConflict = "ip_conflict"
+)
+
+const (
+	alertConflictWindow = 5 * time.Minute //该时间内两个MAC声明同一IP视为冲突
+	alertRepeat         = time.Hour       //相同告警的最小间隔
+)
+
+type ipOwner struct {
+	mac  string
+	seen time.Time
+}
+
+// 告警检测：根据ARP数据发现新机器、IP对应的MAC变化和IP冲突
+type AlertDetector struct {
+	lock      sync.Mutex
+	enable    atomic.Bool
+	known     map[string]bool
+	trusted   map[string]bool
+	owners    map[string]ipOwner
+	lastAlert map[string]time.Time
+}
+
+func (a *AlertDetector) Start() {
+	dbObj := db.DBOperObj().GetDB()
+
+	macInfos := []db.MacInfo{}
+	dbObj.Find(&macInfos)
+
+	attachs := []db.AttachInfo{}
+	dbObj.Where("trusted=?", true).Find(&attachs)
+
+	a.lock.Lock()
+	for _, v := range macInfos {
+		a.known[v.Mac] = true
+		if len(v.IP) != 0 {
+			a.owners[v.IP] = ipOwner{mac: v.Mac}
+		}
+	}
+
+	for _, v := range attachs {
+		a.trusted[v.Mac] = true
+	}
+	a.lock.Unlock()
+
+	a.Reload()
+
+	NetProtoObj().AddArpRetFun("alert", func(info IpInfo) {
+		a.Check(info)
+	})
+}
+
+// 重新加载告警开关，修改配置后调用
+func (a *AlertDetector) Reload() {
+	a.enable.Store(db.DBOperObj().GetConfig().AlertEnable)
+}
+
+// 设置可信机器
+func (a *AlertDetector) SetTrusted(mac string, trusted bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if trusted {
+		a.trusted[mac] = true
+	} else {
+		delete(a.trusted, mac)
+	}
+}
+
+// 检查ARP数据
+func (a *AlertDetector) Check(info IpInfo) {
+	if !a.enable.Load() || info.IP.To4() == nil || len(info.Mac) == 0 {
+		return
+	}
+
+	mac := info.Mac.String()
+	ip := info.IP.String()
+	now := time.Now()
+
+	a.lock.Lock()
+	isNew := !a.known[mac]
+	a.known[mac] = true
+
+	owner, hasOwner := a.owners[ip]
+	a.owners[ip] = ipOwner{mac: mac, seen: now}
+	a.lock.Unlock()
+
+	if isNew {
+		a.alert(AlertNewDevice, mac, ip,
+			fmt.Sprintf("发现新机器，MAC：%s，IP：%s，厂商：%s，网卡：%s", mac, ip, info.MANUF, info.Iface))
+	}
+
+	if !hasOwner || owner.mac == mac {
+		return
+	}
+
+	if now.Sub(owner.seen) < alertConflictWindow {
+		a.alert(AlertIPConflict, mac, ip,
+			fmt.Sprintf("IP冲突，IP：%s，同时被 %s 和 %s 使用", ip, owner.mac, mac))
+		return
+	}
+
+	a.alert(AlertMacChange, mac, ip,
+		fmt.Sprintf("MAC变化，IP：%s，由 %s 变为 %s", ip, owner.mac, mac))
+}
+
+// 记录日志并推送，可信机器不告警
+func (a *AlertDetector) alert(kind string, mac string, ip string, msg string) {
+	key := strings.Join([]string{kind, mac, ip}, "|")
+	now := time.Now()
+
+	a.lock.Lock()
+	if a.trusted[mac] || now.Sub(a.lastAlert[key]) < alertRepeat {
+		a.lock.Unlock()
+		return
+	}
+
+	a.lastAlert[key] = now
+
+	//清理过期记录
+	if len(a.lastAlert) > 1024 {
+		for k, v := range a.lastAlert {
+			if now.Sub(v) >= alertRepeat {
+				delete(a.lastAlert, k)
+			}
+		}
+	}
+	a.lock.Unlock()
+
+	db.DBLog("网络告警", "%s", msg)
+	PushMsgAsync(msg)
+}
+
+var alertOnce sync.Once
+var alertObj *AlertDetector
+
+func AlertObj() *AlertDetector {
+	alertOnce.Do(func() {
+		alertObj = &AlertDetector{
+			known:     make(map[string]bool),
+			trusted:   make(map[string]bool),
+			owners:    make(map[string]ipOwner),
+			lastAlert: make(map[string]time.Time),
+		}
+	})
+
+	return alertObj
+}
@@ -0,0 +1,176 @@
+package network
+
+import (
+	"errors"
+	"sync"
+	"time"
+	"wakelan/backend/comm"
+	"wakelan/backend/db"
+)
+
+// 分组唤醒成员状态
+const (
+	GroupMemberPending = "pending"
+	GroupMemberSent    = "sent"
+	GroupMemberError   = "error"
+)
+
+// 成员唤醒间隔的上限，秒
+const GroupMaxDelay = 600
+
+// 分组唤醒任务状态
+const (
+	GroupWakeRunning = "running"
+	GroupWakeDone    = "done"
+)
+
+type GroupWakeMember struct {
+	Mac   string `json:"mac"`
+	State string `json:"state"`
+	Err   string `json:"err"`
+	Time  string `json:"time"`
+}
+
+type GroupWakeInfo struct {
+	ID      string            `json:"id"`
+	GroupID uint              `json:"group_id"`
+	Name    string            `json:"name"`
+	Delay   int               `json:"delay"`
+	State   string            `json:"state"`
+	Members []GroupWakeMember `json:"members"`
+}
+
+func (i GroupWakeInfo) IsDone() bool {
+	return i.State == GroupWakeDone
+}
+
+type groupWakeJob struct {
+	jobBase[GroupWakeInfo]
+	info GroupWakeInfo
+}
+
+// 复制当前状态，避免成员切片被并发修改
+func (j *groupWakeJob) snapshot() GroupWakeInfo {
+	info := j.info
+	info.Members = append([]GroupWakeMember{}, j.info.Members...)
+	return info
+}
+
+type GroupWakeManager struct {
+	jobManager[GroupWakeInfo, *groupWakeJob]
+}
+
+// 启动分组唤醒，delay小于0时使用分组配置的间隔(秒)
+func (g *GroupWakeManager) Start(groupID uint, delay int) (string, error) {
+	job, err := g.newJob(groupID, delay)
+	if err != nil {
+		return "", err
+	}
+
+	go g.run(job)
+
+	return job.info.ID, nil
+}
+
+// 分组唤醒并等待全部成员发送完成，用于定时任务
+func (g *GroupWakeManager) Wake(groupID uint, delay int) (GroupWakeInfo, error) {
+	job, err := g.newJob(groupID, delay)
+	if err != nil {
+		return GroupWakeInfo{}, err
+	}
+
+	g.run(job)
+
+	return g.GetJob(job.info.ID)
+}
+
+func (g *GroupWakeManager) newJob(groupID uint, delay int) (*groupWakeJob, error) {
+	group, err := db.GetGroup(groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(group.Members) == 0 {
+		return nil, errors.New("分组没有成员")
+	}
+
+	if delay < 0 {
+		delay = group.Delay
+	}
+
+	if delay > GroupMaxDelay {
+		delay = GroupMaxDelay
+	}
+
+	job := &groupWakeJob{
+		info: GroupWakeInfo{
+			ID:      comm.GenRandKey(),
+			GroupID: group.ID,
+			Name:    group.Name,
+			Delay:   delay,
+			State:   GroupWakeRunning,
+		},
+	}
+
+	for _, m := range group.Members {
+		job.info.Members = append(job.info.Members, GroupWakeMember{
+			Mac:   m.Mac,
+			State: GroupMemberPending,
+		})
+	}
+
+	g.add(job.info.ID, job)
+
+	return job, nil
+}
+
+func (g *GroupWakeManager) run(job *groupWakeJob) {
+	defer g.remove(job.info.ID)
+
+	failed := 0
+
+	for i := range job.info.Members {
+		//间隔唤醒，避免同时上电
+		if i != 0 && job.info.Delay > 0 {
+			time.Sleep(time.Duration(job.info.Delay) * time.Second)
+		}
+
+		err := NetProtoObj().WakeDevice(job.info.Members[i].Mac)
+
+		job.update(func() GroupWakeInfo {
+			member := &job.info.Members[i]
+			member.Time = time.Now().Format(comm.TimeFormat)
+			if err != nil {
+				member.State = GroupMemberError
+				member.Err = err.Error()
+			} else {
+				member.State = GroupMemberSent
+			}
+
+			return job.snapshot()
+		})
+
+		if err != nil {
+			failed++
+		}
+	}
+
+	job.update(func() GroupWakeInfo {
+		job.info.State = GroupWakeDone
+		return job.snapshot()
+	})
+
+	db.DBLog("分组唤醒", "分组：%s，成员：%d，失败：%d，间隔：%d秒",
+		job.info.Name, len(job.info.Members), failed, job.info.Delay)
+}
+
+var groupWakeOnce sync.Once
+var groupWakeObj *GroupWakeManager
+
+func GroupWakeMG() *GroupWakeManager {
+	groupWakeOnce.Do(func() {
+		groupWakeObj = &GroupWakeManager{}
+	})
+
+	return groupWakeObj
+}
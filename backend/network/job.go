@@ -0,0 +1,136 @@
+package network
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// 任务结束后保留的时间，便于查询结果
+const jobKeepTime = 10 * time.Minute
+
+// 任务信息，推送给订阅者
+type JobInfo interface {
+	IsDone() bool
+}
+
+// 订阅任务状态，websocket推送使用
+type JobSubscriber[T JobInfo] interface {
+	Subscribe(id string, flag string, fun func(info T)) (T, error)
+	Unsubscribe(id string, flag string)
+}
+
+// 任务的锁和订阅者，由具体任务嵌入
+type jobBase[T JobInfo] struct {
+	lock sync.Mutex
+	funs map[string]func(info T)
+}
+
+func (b *jobBase[T]) base() *jobBase[T] {
+	return b
+}
+
+// 在锁内修改任务状态并返回当前状态，之后通知订阅者
+func (b *jobBase[T]) update(fun func() T) {
+	b.lock.Lock()
+	info := fun()
+
+	funs := []func(info T){}
+	for _, f := range b.funs {
+		funs = append(funs, f)
+	}
+	b.lock.Unlock()
+
+	for _, f := range funs {
+		f(info)
+	}
+}
+
+type jobItem[T JobInfo] interface {
+	base() *jobBase[T]
+	snapshot() T //当前状态，lock需已锁定
+}
+
+// 任务管理：保存任务、订阅状态，任务结束后保留一段时间
+type jobManager[T JobInfo, J jobItem[T]] struct {
+	jobLock sync.Mutex
+	jobs    map[string]J
+}
+
+func (m *jobManager[T, J]) add(id string, job J) {
+	m.jobLock.Lock()
+	defer m.jobLock.Unlock()
+
+	if m.jobs == nil {
+		m.jobs = make(map[string]J)
+	}
+
+	m.jobs[id] = job
+}
+
+func (m *jobManager[T, J]) get(id string) (J, bool) {
+	m.jobLock.Lock()
+	defer m.jobLock.Unlock()
+
+	job, ok := m.jobs[id]
+	return job, ok
+}
+
+// 任务结束后调用，保留一段时间后删除
+func (m *jobManager[T, J]) remove(id string) {
+	time.AfterFunc(jobKeepTime, func() {
+		m.jobLock.Lock()
+		defer m.jobLock.Unlock()
+		delete(m.jobs, id)
+	})
+}
+
+// 订阅任务状态，返回当前状态，任务已结束时不再订阅
+func (m *jobManager[T, J]) Subscribe(id string, flag string, fun func(info T)) (T, error) {
+	job, ok := m.get(id)
+	if !ok {
+		var info T
+		return info, errors.New("job not found")
+	}
+
+	b := job.base()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	info := job.snapshot()
+	if !info.IsDone() {
+		if b.funs == nil {
+			b.funs = make(map[string]func(info T))
+		}
+
+		b.funs[flag] = fun
+	}
+
+	return info, nil
+}
+
+func (m *jobManager[T, J]) Unsubscribe(id string, flag string) {
+	job, ok := m.get(id)
+	if !ok {
+		return
+	}
+
+	b := job.base()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.funs, flag)
+}
+
+func (m *jobManager[T, J]) GetJob(id string) (T, error) {
+	job, ok := m.get(id)
+	if !ok {
+		var info T
+		return info, errors.New("job not found")
+	}
+
+	b := job.base()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return job.snapshot(), nil
+}
@@ -2,90 +2,358 @@ package network
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
+	_ "embed"
+	"encoding/csv"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"wakelan/backend/comm"
 )
 
-const hexDigit = "0123456789ABCDEF"
+// 内置的Wireshark manuf文件，外部文件不存在时使用
+//
+//go:embed manuf.gz
+var embedManuf []byte
 
-var d map[int]interface{}
+// 程序目录下的厂商数据库文件，按顺序加载，后加载的覆盖先加载的
+var manufFiles = []string{"manuf", "oui.csv", "mam.csv", "oui36.csv"}
 
-func init() {
-	d = make(map[int]interface{})
-	file := os.Args[0]
-	f := filepath.Join(filepath.Dir(file), "manuf")
-	err := readLine(f, func(s string) {
-		l := strings.Split(s, "\t")
-		if len(l) > 2 {
-			parse(l[0], l[2])
-		}
-	})
-	if err != nil {
-		panic(err)
+// IEEE注册类型对应的文件
+var registryFiles = map[string]string{
+	"MA-L": "oui.csv",
+	"MA-M": "mam.csv",
+	"MA-S": "oui36.csv",
+}
+
+// 厂商数据库：前缀长度 -> 前缀 -> 厂商
+type ouiDB struct {
+	prefixes map[int]map[uint64]string
+	bits     []int //前缀长度，从长到短
+}
+
+func newOuiDB() *ouiDB {
+	return &ouiDB{
+		prefixes: make(map[int]map[uint64]string),
 	}
 }
 
-func parse(mac, comment string) {
-	g := strings.Split(mac, "/")
-	m := strings.Split(g[0], ":")
-	var b int
-	if len(g) != 2 {
-		b = 48 - len(m)*8
-	} else {
-		b, _ = strconv.Atoi(g[1])
+func (o *ouiDB) add(prefix uint64, bits int, name string) {
+	if bits <= 0 || bits > 48 || len(name) == 0 {
+		return
 	}
-	if _, ok := d[b]; !ok {
-		d[b] = make(map[uint64]string)
 
+	prefix = prefix >> uint(48-bits) << uint(48-bits)
+
+	if _, ok := o.prefixes[bits]; !ok {
+		o.prefixes[bits] = make(map[uint64]string)
+		o.bits = append(o.bits, bits)
+		sort.Sort(sort.Reverse(sort.IntSlice(o.bits)))
 	}
-	d[b].(map[uint64]string)[b2uint64(m)] = comment
+
+	o.prefixes[bits][prefix] = name
 }
 
-func b2uint64(sList []string) uint64 {
-	var t uint64
-	for i, b := range sList {
-		l := strings.Index(hexDigit, string(b[0]))
-		r := strings.Index(hexDigit, string(b[1]))
-		t += uint64((l<<4)+r) << uint8((6-i-1)*8)
+func (o *ouiDB) merge(other *ouiDB) {
+	for bits, prefixes := range other.prefixes {
+		for prefix, name := range prefixes {
+			o.add(prefix, bits, name)
+		}
+	}
+}
+
+func (o *ouiDB) count() int {
+	total := 0
+	for _, v := range o.prefixes {
+		total += len(v)
 	}
 
-	return t
+	return total
 }
 
-func Search(mac string) string {
-	s := strings.Split(strings.ToUpper(mac), ":")
-	bint := b2uint64(s)
-	for b := range d {
-		k := 48 - b
-		bint = (bint >> uint8(k)) << uint8(k)
-		if _, ok := d[b].(map[uint64]string)[bint]; ok {
-			return d[b].(map[uint64]string)[bint]
+// 最长前缀匹配
+func (o *ouiDB) search(mac uint64) string {
+	for _, bits := range o.bits {
+		prefix := mac >> uint(48-bits) << uint(48-bits)
+		if name, ok := o.prefixes[bits][prefix]; ok {
+			return name
 		}
 	}
+
 	return ""
 }
 
-func readLine(fileName string, handler func(string)) error {
-	f, err := os.Open(fileName)
-	if err != nil {
-		return err
+// 解析十六进制MAC前缀，忽略分隔符，返回左对齐的值和位数
+func parsePrefix(str string) (uint64, int, error) {
+	var value uint64
+	digits := 0
+
+	for _, c := range str {
+		if c == ':' || c == '-' || c == '.' {
+			continue
+		}
+
+		v := strings.IndexRune("0123456789ABCDEF", c)
+		if v < 0 {
+			v = strings.IndexRune("0123456789abcdef", c)
+		}
+
+		if v < 0 || digits >= 12 {
+			return 0, 0, errors.New("prefix format error")
+		}
+
+		value = value<<4 | uint64(v)
+		digits++
+	}
+
+	if digits == 0 {
+		return 0, 0, errors.New("prefix format error")
 	}
 
-	defer f.Close()
+	return value << uint(48-digits*4), digits * 4, nil
+}
 
-	buf := bufio.NewReader(f)
+// 解析Wireshark manuf格式：前缀[/位数] 简称 [全称]
+func parseManuf(r io.Reader) (*ouiDB, error) {
+	db := newOuiDB()
+
+	buf := bufio.NewScanner(r)
+	for buf.Scan() {
+		line := strings.TrimSpace(buf.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		l := strings.Split(line, "\t")
+		if len(l) < 2 {
+			continue
+		}
+
+		name := strings.TrimSpace(l[1])
+		if len(l) > 2 && len(strings.TrimSpace(l[2])) != 0 {
+			name = strings.TrimSpace(l[2])
+		}
+
+		g := strings.Split(l[0], "/")
+		prefix, bits, err := parsePrefix(g[0])
+		if err != nil {
+			continue
+		}
+
+		if len(g) == 2 {
+			bits, err = strconv.Atoi(g[1])
+			if err != nil {
+				continue
+			}
+		}
+
+		db.add(prefix, bits, name)
+	}
+
+	return db, buf.Err()
+}
+
+// 解析IEEE oui.csv、mam.csv、oui36.csv，返回注册类型
+func parseIEEE(r io.Reader) (*ouiDB, string, error) {
+	db := newOuiDB()
+	registry := ""
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header := true
 	for {
-		line, err := buf.ReadString('\n')
-		line = strings.TrimSpace(line)
-		handler(line)
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
 		if err != nil {
-			if err == io.EOF {
-				return nil
+			return nil, "", err
+		}
+
+		if header {
+			header = false
+			continue
+		}
+
+		if len(record) < 3 {
+			continue
+		}
+
+		prefix, bits, err := parsePrefix(strings.TrimSpace(record[1]))
+		if err != nil {
+			continue
+		}
+
+		registry = strings.TrimSpace(record[0])
+		db.add(prefix, bits, strings.TrimSpace(record[2]))
+	}
+
+	return db, registry, nil
+}
+
+// 按内容识别格式并解析，返回保存的文件名
+func parseManufData(data []byte) (*ouiDB, string, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	var db *ouiDB
+	var fileName string
+	var err error
+
+	if bytes.HasPrefix(data, []byte("Registry,")) {
+		var registry string
+		db, registry, err = parseIEEE(bytes.NewReader(data))
+		if err != nil {
+			return nil, "", err
+		}
+
+		var ok bool
+		fileName, ok = registryFiles[registry]
+		if !ok {
+			return nil, "", errors.New("unknown registry: " + registry)
+		}
+	} else {
+		db, err = parseManuf(bytes.NewReader(data))
+		if err != nil {
+			return nil, "", err
+		}
+
+		fileName = "manuf"
+	}
+
+	if db.count() == 0 {
+		return nil, "", errors.New("no vendor found")
+	}
+
+	return db, fileName, nil
+}
+
+func loadEmbedManuf() *ouiDB {
+	r, err := gzip.NewReader(bytes.NewReader(embedManuf))
+	if err != nil {
+		return newOuiDB()
+	}
+
+	defer r.Close()
+
+	db, err := parseManuf(r)
+	if err != nil {
+		return newOuiDB()
+	}
+
+	return db
+}
+
+var ouiLock sync.RWMutex
+var oui *ouiDB
+
+// 加载厂商数据库：内置数据，再由程序目录下的文件覆盖
+func LoadManuf() (int, error) {
+	db := loadEmbedManuf()
+
+	//启动时数据库尚未打开，跳过的文件输出到控制台
+	for _, name := range manufFiles {
+		data, err := os.ReadFile(filepath.Join(comm.Pwd(), name))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				fmt.Printf("厂商数据库 %s 读取失败，已跳过：%s\n", name, err.Error())
 			}
-			return err
+			continue
 		}
+
+		fileDB, _, err := parseManufData(data)
+		if err != nil {
+			fmt.Printf("厂商数据库 %s 解析失败，已跳过：%s\n", name, err.Error())
+			continue
+		}
+
+		db.merge(fileDB)
+	}
+
+	if db.count() == 0 {
+		return 0, errors.New("no vendor found")
+	}
+
+	ouiLock.Lock()
+	oui = db
+	ouiLock.Unlock()
+
+	return db.count(), nil
+}
+
+// 保存上传的厂商数据库并重新加载，返回保存的文件名和条目数
+// 先写入临时文件，重新读取解析成功后再替换，避免留下无法加载的文件
+func UpdateManuf(data []byte) (string, int, error) {
+	_, fileName, err := parseManufData(data)
+	if err != nil {
+		return "", 0, err
 	}
+
+	tmp, err := os.CreateTemp(comm.Pwd(), fileName+".*.tmp")
+	if err != nil {
+		return "", 0, err
+	}
+
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return "", 0, err
+	}
+
+	saved, err := os.ReadFile(tmpName)
+	if err != nil {
+		return "", 0, err
+	}
+
+	_, _, err = parseManufData(saved)
+	if err != nil {
+		return "", 0, err
+	}
+
+	err = os.Chmod(tmpName, 0644)
+	if err != nil {
+		return "", 0, err
+	}
+
+	err = os.Rename(tmpName, filepath.Join(comm.Pwd(), fileName))
+	if err != nil {
+		return "", 0, err
+	}
+
+	count, err := LoadManuf()
+	return fileName, count, err
+}
+
+func init() {
+	LoadManuf()
+}
+
+func Search(mac string) string {
+	prefix, bits, err := parsePrefix(mac)
+	if err != nil || bits != 48 {
+		return ""
+	}
+
+	ouiLock.RLock()
+	defer ouiLock.RUnlock()
+
+	if oui == nil {
+		return ""
+	}
+
+	return oui.search(prefix)
 }
@@ -0,0 +1,347 @@
+package network
+
+import (
+	"net"
+	"os"
+	"sort"
+	"time"
+	"wakelan/backend/comm"
+	"wakelan/backend/db"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// 前缀主机位不超过该值时逐个探测，否则只探测由已知MAC生成的EUI-64地址
+const ndpSweepBits = 12
+
+const (
+	ipv6MaxAddrs = 8              //每台机器最多记录的IPv6地址数，临时地址会不断变化
+	ipv6MaxAge   = 24 * time.Hour //超过该时间未收到数据的IPv6地址过期
+)
+
+var allNodesIPv6 = net.ParseIP("ff02::1")
+
+// IPv6组播地址对应的以太网地址
+func ipv6MulticastMac(ip net.IP) net.HardwareAddr {
+	ip = ip.To16()
+	return net.HardwareAddr{0x33, 0x33, ip[12], ip[13], ip[14], ip[15]}
+}
+
+// 请求节点组播地址 ff02::1:ffXX:XXXX
+func solicitedNodeIP(ip net.IP) net.IP {
+	ip = ip.To16()
+	snIP := net.ParseIP("ff02::1:ff00:0")
+	copy(snIP[13:], ip[13:])
+	return snIP
+}
+
+// 由MAC生成EUI-64接口标识
+func eui64IP(prefix net.IP, mac net.HardwareAddr) net.IP {
+	if len(mac) != 6 {
+		return nil
+	}
+
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, prefix.To16()[:8])
+	ip[8] = mac[0] ^ 0x02
+	ip[9] = mac[1]
+	ip[10] = mac[2]
+	ip[11] = 0xff
+	ip[12] = 0xfe
+	ip[13] = mac[3]
+	ip[14] = mac[4]
+	ip[15] = mac[5]
+
+	return ip
+}
+
+func isIPv6(ip net.IP) bool {
+	return ip.To4() == nil && ip.To16() != nil
+}
+
+func (n *NetProto) writeICMPv6(c *netCard, dstMac net.HardwareAddr, srcIP, dstIP net.IP, icmp *layers.ICMPv6, payloads ...gopacket.SerializableLayer) error {
+	eth := layers.Ethernet{
+		SrcMAC:       c.iface.HardwareAddr,
+		DstMAC:       dstMac,
+		EthernetType: layers.EthernetTypeIPv6,
+	}
+
+	ipLayer := layers.IPv6{
+		Version:    6,
+		SrcIP:      srcIP,
+		DstIP:      dstIP,
+		NextHeader: layers.IPProtocolICMPv6,
+		HopLimit:   255,
+	}
+
+	icmp.SetNetworkLayerForChecksum(&ipLayer)
+
+	opt := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+
+	buf := gopacket.NewSerializeBuffer()
+	layerList := append([]gopacket.SerializableLayer{&eth, &ipLayer, icmp}, payloads...)
+	err := gopacket.SerializeLayers(buf, opt, layerList...)
+	if err != nil {
+		return err
+	}
+
+	return c.write(buf.Bytes())
+}
+
+// 邻居请求
+func (n *NetProto) solicit(c *netCard, srcIP, dstIP net.IP) error {
+	snIP := solicitedNodeIP(dstIP)
+
+	icmp := &layers.ICMPv6{
+		TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeNeighborSolicitation, 0),
+	}
+
+	ns := &layers.ICMPv6NeighborSolicitation{
+		TargetAddress: dstIP,
+		Options: layers.ICMPv6Options{
+			{Type: layers.ICMPv6OptSourceAddress, Data: c.iface.HardwareAddr},
+		},
+	}
+
+	return n.writeICMPv6(c, ipv6MulticastMac(snIP), srcIP, snIP, icmp, ns)
+}
+
+// ICMPv6回显请求，单播地址使用请求节点组播MAC，无需先解析邻居
+func (n *NetProto) ping6(c *netCard, srcIP, dstIP net.IP, seq uint16) error {
+	dstMac := ipv6MulticastMac(dstIP)
+	if !dstIP.IsMulticast() {
+		dstMac = ipv6MulticastMac(solicitedNodeIP(dstIP))
+	}
+
+	icmp := &layers.ICMPv6{
+		TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeEchoRequest, 0),
+	}
+
+	echo := &layers.ICMPv6Echo{
+		Identifier: uint16(os.Getpid()),
+		SeqNumber:  seq,
+	}
+
+	return n.writeICMPv6(c, dstMac, srcIP, dstIP, icmp, echo, gopacket.Payload(makeEchoPayload(time.Now())))
+}
+
+func (n *NetProto) PingNet6(ips []net.IP) error {
+	return n.pingNet6(ips, n.nextPingSeq())
+}
+
+func (n *NetProto) pingNet6(ips []net.IP, seq uint16) error {
+	for _, ip := range ips {
+		for _, c := range n.cardsForIP(ip) {
+			srcIP := c.ipv6Src(ip)
+			if srcIP == nil {
+				continue
+			}
+
+			err := n.ping6(c, srcIP, ip, seq)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// IPv6探测：组播ping ff02::1，并对各前缀发送邻居请求
+func (n *NetProto) QueryNet6(millisecond int) error {
+	err := n.pingAllNodes6()
+	if err != nil {
+		return err
+	}
+
+	for _, target := range n.ipv6Targets() {
+		err := n.solicit(target.card, target.src, target.dst)
+		if err != nil {
+			return err
+		}
+
+		if millisecond != 0 {
+			time.Sleep(time.Duration(millisecond) * time.Millisecond)
+		}
+	}
+
+	return nil
+}
+
+// 在各IPv6前缀上组播ping ff02::1
+func (n *NetProto) pingAllNodes6() error {
+	for _, c := range n.getCards() {
+		for _, ipNet := range c.ipv6Nets() {
+			err := n.ping6(c, ipNet.IP, allNodesIPv6, n.nextPingSeq())
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// 邻居请求的目标：前缀较小时逐个地址，否则为已知MAC生成的EUI-64地址
+func (n *NetProto) ipv6Targets() []scanTarget {
+	//已知机器的MAC，用于生成EUI-64地址
+	macs := map[string]net.HardwareAddr{}
+	macInfos := []db.MacInfo{}
+	db.DBOperObj().GetDB().Select("mac").Find(&macInfos)
+	for _, info := range macInfos {
+		mac, err := net.ParseMAC(info.Mac)
+		if err == nil {
+			macs[mac.String()] = mac
+		}
+	}
+
+	n.lock.Lock()
+	for k, v := range n.ipinfos {
+		macs[k] = v.Mac
+	}
+	n.lock.Unlock()
+
+	targets := []scanTarget{}
+	for _, c := range n.getCards() {
+		for _, ipNet := range c.ipv6Nets() {
+			ones, bits := ipNet.Mask.Size()
+
+			ips := []net.IP{}
+			if bits-ones <= ndpSweepBits {
+				ips = comm.MakeIPs(*ipNet)
+			} else if ones <= 64 {
+				for _, mac := range macs {
+					ips = append(ips, eui64IP(ipNet.IP, mac))
+				}
+			}
+
+			for _, ip := range ips {
+				if ip == nil || ip.Equal(ipNet.IP) {
+					continue
+				}
+
+				targets = append(targets, scanTarget{card: c, src: ipNet.IP, dst: ip})
+			}
+		}
+	}
+
+	return targets
+}
+
+// 记录机器的IPv6地址
+func (n *NetProto) learnIPv6(src pktSource, mac net.HardwareAddr, ip net.IP) {
+	if len(mac) == 0 || !isIPv6(ip) || ip.IsUnspecified() || ip.IsMulticast() || n.isLocalMac(mac) {
+		return
+	}
+
+	strMac := mac.String()
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	info, ok := n.ipinfos[strMac]
+	if !ok {
+		info = IpInfo{
+			Mac:      append(net.HardwareAddr{}, mac...),
+			MANUF:    Search(strMac),
+			Hostname: n.hostnames[strMac],
+		}
+	}
+
+	seen, ok := n.ipv6Seen[strMac]
+	if !ok {
+		seen = make(map[string]time.Time)
+		n.ipv6Seen[strMac] = seen
+	}
+
+	now := time.Now()
+	seen[ip.String()] = now
+
+	info.Iface = src.card.name
+	info.VLAN = src.vlan
+	info.IPv6 = n.ipv6List(strMac, now)
+	info.IPv6Learned = true
+	n.ipinfos[strMac] = info
+}
+
+// 机器当前的IPv6地址：清除过期地址，超过数量时丢弃最久未发现的地址，调用者持有锁
+func (n *NetProto) ipv6List(mac string, now time.Time) []net.IP {
+	seen := n.ipv6Seen[mac]
+
+	addrs := []string{}
+	for k, v := range seen {
+		if now.Sub(v) > ipv6MaxAge {
+			delete(seen, k)
+			continue
+		}
+
+		addrs = append(addrs, k)
+	}
+
+	if len(addrs) > ipv6MaxAddrs {
+		sort.Slice(addrs, func(i, j int) bool {
+			return seen[addrs[i]].After(seen[addrs[j]])
+		})
+
+		for _, k := range addrs[ipv6MaxAddrs:] {
+			delete(seen, k)
+		}
+
+		addrs = addrs[:ipv6MaxAddrs]
+	}
+
+	ips := []net.IP{}
+	for _, k := range addrs {
+		ips = append(ips, net.ParseIP(k))
+	}
+
+	sort.Slice(ips, func(i, j int) bool {
+		return comm.IpLess(ips[i], ips[j])
+	})
+
+	return ips
+}
+
+// 处理ICMPv6：回显应答、邻居请求和邻居通告
+func (n *NetProto) handleICMPv6(src pktSource, p gopacket.Packet) {
+	pkg := p.Layer(layers.LayerTypeICMPv6)
+	if pkg == nil {
+		return
+	}
+
+	icmp := pkg.(*layers.ICMPv6)
+
+	ethPkg := p.Layer(layers.LayerTypeEthernet)
+	ipPkg := p.Layer(layers.LayerTypeIPv6)
+	if ethPkg == nil || ipPkg == nil {
+		return
+	}
+
+	eth := ethPkg.(*layers.Ethernet)
+	ipLayer := ipPkg.(*layers.IPv6)
+
+	switch icmp.TypeCode.Type() {
+	case layers.ICMPv6TypeEchoReply:
+		n.learnIPv6(src, eth.SrcMAC, ipLayer.SrcIP)
+
+		if n.isLocalMac(eth.SrcMAC) {
+			return
+		}
+
+		if echoPkg := p.Layer(layers.LayerTypeICMPv6Echo); echoPkg != nil {
+			echo := echoPkg.(*layers.ICMPv6Echo)
+			n.handleEchoReply(p, ipLayer.SrcIP.String(), eth.SrcMAC.String(), echo.Identifier, echo.SeqNumber, echo.Payload)
+		}
+
+		tfuns := n.copyPingFuns()
+
+		go func(ip, mac string) {
+			for _, fun := range tfuns {
+				fun(ip, mac)
+			}
+		}(ipLayer.SrcIP.String(), eth.SrcMAC.String())
+	case layers.ICMPv6TypeNeighborSolicitation, layers.ICMPv6TypeNeighborAdvertisement:
+		n.learnIPv6(src, eth.SrcMAC, ipLayer.SrcIP)
+	}
+}
@@ -0,0 +1,191 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+// 已打开的网卡，每个网卡独立抓包
+type netCard struct {
+	name      string //pcap名称
+	desc      string
+	iface     *net.Interface
+	lock      sync.Mutex //保护handle，关闭时不能有正在进行的写入
+	handle    *pcap.Handle
+	vlan      int //802.1Q子接口的VLAN ID，0表示未打标签
+	cancelFun context.CancelFunc
+	ctx       context.Context
+}
+
+// 数据包来源
+type pktSource struct {
+	card *netCard
+	vlan int
+}
+
+// 网卡信息
+type CardInfo struct {
+	Name  string   `json:"name"`
+	Desc  string   `json:"desc"`
+	Iface string   `json:"iface"`
+	Mac   string   `json:"mac"`
+	VLAN  int      `json:"vlan"`
+	IPS   []string `json:"ips"`
+}
+
+// 子接口名称中的VLAN ID，如eth0.10、eth0@10
+func parseVLAN(name string) int {
+	idx := strings.LastIndexAny(name, ".@")
+	if idx < 0 {
+		return 0
+	}
+
+	vlan, err := strconv.Atoi(name[idx+1:])
+	if err != nil || vlan <= 0 || vlan >= 4095 {
+		return 0
+	}
+
+	return vlan
+}
+
+func (c *netCard) info() CardInfo {
+	info := CardInfo{
+		Name:  c.name,
+		Desc:  c.desc,
+		Iface: c.iface.Name,
+		Mac:   c.iface.HardwareAddr.String(),
+		VLAN:  c.vlan,
+		IPS:   []string{},
+	}
+
+	for _, ipNet := range c.ipNets() {
+		info.IPS = append(info.IPS, ipNet.IP.String())
+	}
+
+	return info
+}
+
+func (c *netCard) ipNets() []*net.IPNet {
+	nets := []*net.IPNet{}
+
+	addrs, err := c.iface.Addrs()
+	if err != nil {
+		return nets
+	}
+
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if ok {
+			nets = append(nets, ipNet)
+		}
+	}
+
+	return nets
+}
+
+func (c *netCard) ipv4Nets() []*net.IPNet {
+	nets := []*net.IPNet{}
+	for _, ipNet := range c.ipNets() {
+		if ipNet.IP.To4() != nil {
+			nets = append(nets, ipNet)
+		}
+	}
+
+	return nets
+}
+
+func (c *netCard) ipv6Nets() []*net.IPNet {
+	nets := []*net.IPNet{}
+	for _, ipNet := range c.ipNets() {
+		if isIPv6(ipNet.IP) {
+			nets = append(nets, ipNet)
+		}
+	}
+
+	return nets
+}
+
+// 包含ip的网段
+func (c *netCard) netOf(ip net.IP) *net.IPNet {
+	for _, ipNet := range c.ipNets() {
+		if ipNet.Contains(ip) {
+			return ipNet
+		}
+	}
+
+	return nil
+}
+
+// 发往ip的IPv4源地址，不在同一网段时使用第一个地址
+func (c *netCard) ipv4Src(ip net.IP) net.IP {
+	if ipNet := c.netOf(ip); ipNet != nil {
+		return ipNet.IP
+	}
+
+	nets := c.ipv4Nets()
+	if len(nets) == 0 {
+		return nil
+	}
+
+	return nets[0].IP
+}
+
+// 选择与目标同前缀的IPv6源地址，没有时使用链路本地地址
+func (c *netCard) ipv6Src(dst net.IP) net.IP {
+	var linkLocal net.IP
+
+	for _, ipNet := range c.ipv6Nets() {
+		if ipNet.Contains(dst) {
+			return ipNet.IP
+		}
+
+		if ipNet.IP.IsLinkLocalUnicast() {
+			linkLocal = ipNet.IP
+		}
+	}
+
+	return linkLocal
+}
+
+func (c *netCard) write(data []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.handle == nil {
+		return errors.New("network not open")
+	}
+
+	return c.handle.WritePacketData(data)
+}
+
+func (c *netCard) close() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.cancelFun != nil {
+		c.cancelFun()
+		c.cancelFun = nil
+	}
+
+	if c.handle != nil {
+		c.handle.Close()
+		c.handle = nil
+	}
+}
+
+// 数据包的VLAN，未打标签时使用网卡的VLAN
+func (c *netCard) packetVLAN(p gopacket.Packet) int {
+	if pkg := p.Layer(layers.LayerTypeDot1Q); pkg != nil {
+		return int(pkg.(*layers.Dot1Q).VLANIdentifier)
+	}
+
+	return c.vlan
+}
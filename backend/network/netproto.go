@@ -1,11 +1,13 @@
 package network
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"net"
 	"os"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -18,50 +20,158 @@ import (
 )
 
 type IpInfo struct {
-	IP    net.IP
-	Mac   net.HardwareAddr
-	MANUF string
+	IP       net.IP
+	Mac      net.HardwareAddr
+	MANUF    string
+	Hostname string
+	IPv6     []net.IP
+	Iface    string //发现该机器的网卡
+	VLAN     int
+
+	IPv6Learned bool //本次运行收到过该机器的IPv6数据，IPv6为空表示地址已全部过期
 }
 
 type ArpRetFun func(info IpInfo)
 type PingRetFun func(ip string, mac string)
 
 type NetProto struct {
-	iface     *net.Interface
-	handle    *pcap.Handle
+	cards     map[string]*netCard
 	ipinfos   map[string]IpInfo
 	lock      sync.Mutex
-	cancelFun context.CancelFunc
-	ctx       context.Context
 	openLock  sync.Mutex
 	pingFuns  map[string]PingRetFun
-	arpFun    ArpRetFun
+	echoFuns  map[string]EchoRetFun
+	arpFuns   map[string]ArpRetFun
+	hostnames map[string]string
+	ipv6Seen  map[string]map[string]time.Time //MAC -> IPv6地址 -> 最近发现时间
+	pingSeq   uint32
 }
 
-func (n *NetProto) Init() error {
+// 读取保存的网卡，兼容只保存一个网卡的旧格式
+func loadCardNames() []string {
+	names := []string{}
+
 	netCard := db.GetNetworkCard()
-	if len(netCard) != 0 {
+	if len(netCard) == 0 {
+		return names
+	}
+
+	datas := []map[string]interface{}{}
+	err := json.Unmarshal([]byte(netCard), &datas)
+	if err != nil {
 		data := map[string]interface{}{}
-		err := json.Unmarshal([]byte(netCard), &data)
-		if err == nil {
-			name := data["name"].(string)
-			if len(name) != 0 {
-				iface, err := n.GetInterfaceByName(name)
-				if err == nil {
-					n.Close()
-					n.Open(iface, true)
-				}
-			}
+		if json.Unmarshal([]byte(netCard), &data) != nil {
+			return names
+		}
+
+		datas = append(datas, data)
+	}
+
+	for _, data := range datas {
+		name, _ := data["name"].(string)
+		if len(name) != 0 {
+			names = append(names, name)
 		}
 	}
 
+	return names
+}
+
+func (n *NetProto) Init() error {
 	n.pingFuns = make(map[string]PingRetFun)
+	n.echoFuns = make(map[string]EchoRetFun)
+	n.arpFuns = make(map[string]ArpRetFun)
+
+	for _, name := range loadCardNames() {
+		iface, err := n.GetInterfaceByName(name)
+		if err == nil {
+			n.Open(iface, true)
+		}
+	}
 
 	return nil
 }
 
-func (n *NetProto) GetLocalInfo() *net.Interface {
-	return n.iface
+// 所有打开的网卡
+func (n *NetProto) GetLocalInfos() []*net.Interface {
+	ifaces := []*net.Interface{}
+	for _, c := range n.getCards() {
+		ifaces = append(ifaces, c.iface)
+	}
+
+	return ifaces
+}
+
+func (n *NetProto) GetCards() []CardInfo {
+	infos := []CardInfo{}
+	for _, c := range n.getCards() {
+		infos = append(infos, c.info())
+	}
+
+	return infos
+}
+
+// 打开的网卡，按名称排序
+func (n *NetProto) getCards() []*netCard {
+	n.openLock.Lock()
+	defer n.openLock.Unlock()
+
+	cards := []*netCard{}
+	for _, c := range n.cards {
+		cards = append(cards, c)
+	}
+
+	sort.Slice(cards, func(i, j int) bool {
+		return cards[i].name < cards[j].name
+	})
+
+	return cards
+}
+
+func (n *NetProto) getCard(name string) *netCard {
+	n.openLock.Lock()
+	defer n.openLock.Unlock()
+	return n.cards[name]
+}
+
+// 发往ip的网卡：ip所在网段的网卡，没有时返回所有网卡
+func (n *NetProto) cardsForIP(ip net.IP) []*netCard {
+	all := n.getCards()
+
+	cards := []*netCard{}
+	for _, c := range all {
+		if c.netOf(ip) != nil {
+			cards = append(cards, c)
+		}
+	}
+
+	if len(cards) == 0 {
+		return all
+	}
+
+	return cards
+}
+
+// 机器所在的网卡和VLAN：最近发现该机器的网卡，未知时返回所有网卡
+func (n *NetProto) cardsForMac(mac string) ([]*netCard, int) {
+	mac = strings.ToLower(mac)
+
+	n.lock.Lock()
+	info, ok := n.ipinfos[mac]
+	n.lock.Unlock()
+
+	if !ok {
+		macInfo := &db.MacInfo{}
+		db.DBOperObj().GetDB().Where("mac=?", mac).Find(macInfo)
+		info.Iface = macInfo.Iface
+		info.VLAN = macInfo.VLAN
+	}
+
+	if c := n.getCard(info.Iface); c != nil {
+		return []*netCard{c}, info.VLAN
+	}
+
+	return n.getCards(), 0
 }
 
 func (n *NetProto) AddPingRetFun(flag string, fun PingRetFun) {
@@ -76,10 +186,39 @@ func (n *NetProto) DelPingRetFun(flag string) {
 	delete(n.pingFuns, flag)
 }
 
-func (n *NetProto) SetArpRetFun(flag string, fun ArpRetFun) {
+func (n *NetProto) AddArpRetFun(flag string, fun ArpRetFun) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.arpFuns[flag] = fun
+}
+
+func (n *NetProto) DelArpRetFun(flag string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	delete(n.arpFuns, flag)
+}
+
+func (n *NetProto) copyPingFuns() map[string]PingRetFun {
 	n.lock.Lock()
 	defer n.lock.Unlock()
-	n.arpFun = fun
+
+	funs := make(map[string]PingRetFun, len(n.pingFuns))
+	for k, v := range n.pingFuns {
+		funs[k] = v
+	}
+
+	return funs
+}
+
+// 是否为本机网卡
+func (n *NetProto) isLocalMac(mac net.HardwareAddr) bool {
+	for _, c := range n.getCards() {
+		if bytes.Equal(c.iface.HardwareAddr, mac) {
+			return true
+		}
+	}
+
+	return false
 }
 
 func (n *NetProto) GetInterfaces() ([]pcap.Interface, error) {
@@ -120,7 +259,7 @@ func (n *NetProto) GetInterfaceByName(name string) (pcap.Interface, error) {
 	return pcap.Interface{}, errors.New("no find")
 }
 
-func (n *NetProto) makePingPkg(srcMac net.HardwareAddr, srcIP, dstIP net.IP) ([]byte, error) {
+func (n *NetProto) makePingPkg(srcMac net.HardwareAddr, srcIP, dstIP net.IP, seq uint16) ([]byte, error) {
 	eth := layers.Ethernet{
 		SrcMAC:       srcMac,                                               // 源 MAC 地址
 		DstMAC:       net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, // 目标 MAC 地址
@@ -140,10 +279,10 @@ func (n *NetProto) makePingPkg(srcMac net.HardwareAddr, srcIP, dstIP net.IP) ([]
 	icmpLayer := layers.ICMPv4{
 		TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoRequest, 0),
 		Id:       uint16(os.Getpid()),
-		Seq:      1,
+		Seq:      seq,
 	}
 
-	data := []byte("abcdefghijklmnopqrstuvwabcdefghi")
+	data := makeEchoPayload(time.Now())
 
 	opt := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
 
@@ -190,112 +329,19 @@ func (n *NetProto) IsOpen() bool {
 	n.openLock.Lock()
 	defer n.openLock.Unlock()
 
-	return n.handle != nil
+	return len(n.cards) != 0
 }
 
+// 打开网卡，已打开的其他网卡保持不变
 func (n *NetProto) Open(iface pcap.Interface, promisc bool) error {
-	n.Close()
-
-	n.openLock.Lock()
-	defer n.openLock.Unlock()
+	n.CloseCard(iface.Name)
 
 	infos, err := net.Interfaces()
 	if err != nil {
 		return err
 	}
 
-	handle, err := pcap.OpenLive(iface.Name, 65536, promisc, pcap.BlockForever)
-	if err != nil {
-		return err
-	}
-
-	ctx, cancelFun := context.WithCancel(context.Background())
-
-	n.handle = handle
-	n.cancelFun = cancelFun
-	n.ctx = ctx
-	n.ipinfos = make(map[string]IpInfo)
-
-	go func(h *pcap.Handle, ctx context.Context) {
-		ps := gopacket.NewPacketSource(h, h.LinkType())
-
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case p := <-ps.Packets():
-				//处理netproto
-				func() {
-					pkg := p.Layer(layers.LayerTypeARP)
-					if pkg == nil {
-						return
-					}
-
-					netproto := pkg.(*layers.ARP)
-					if netproto == nil {
-						return
-					}
-
-					if netproto.Operation != layers.ARPReply {
-						return
-					}
-
-					func() {
-						info := IpInfo{}
-						info.IP = netproto.SourceProtAddress
-						info.Mac = netproto.SourceHwAddress
-						info.MANUF = Search(info.Mac.String())
-
-						if n.arpFun != nil {
-							n.arpFun(info)
-						}
-
-						n.lock.Lock()
-						defer n.lock.Unlock()
-						n.ipinfos[info.Mac.String()] = info
-					}()
-				}()
-
-				//处理icmp
-				func() {
-					pkg := p.Layer(layers.LayerTypeICMPv4)
-					if pkg == nil {
-						return
-					}
-
-					icmp := pkg.(*layers.ICMPv4)
-					if icmp == nil {
-						return
-					}
-
-					if icmp.TypeCode != layers.ICMPv4TypeEchoReply {
-						return
-					}
-
-					tfuns := func() map[string]PingRetFun {
-						n.lock.Lock()
-						defer n.lock.Unlock()
-						return n.pingFuns
-					}()
-
-					if len(tfuns) == 0 {
-						return
-					}
-
-					pkg = p.Layer(layers.LayerTypeEthernet)
-					eth := pkg.(*layers.Ethernet)
-					pkg = p.Layer(layers.LayerTypeIPv4)
-					ipLayer := pkg.(*layers.IPv4)
-
-					go func(ip, mac string) {
-						for _, fun := range tfuns {
-							fun(ip, mac)
-						}
-					}(ipLayer.SrcIP.To4().String(), eth.SrcMAC.String())
-				}()
-			}
-		}
-	}(handle, ctx)
+	var netIface *net.Interface
 
 Open_Fin:
 	for _, i := range infos {
@@ -304,61 +350,197 @@ Open_Fin:
 			ip2, _, _ := net.ParseCIDR(addr.String())
 			for _, ip := range iface.Addresses {
 				if ip.IP.Equal(ip2) {
-					n.iface = &i
+					netIface = &i
 					break Open_Fin
 				}
 			}
 		}
 	}
 
-	if n.iface == nil {
-		n.Close()
+	if netIface == nil {
 		return errors.New("net interface nil")
 	}
 
+	handle, err := pcap.OpenLive(iface.Name, 65536, promisc, pcap.BlockForever)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancelFun := context.WithCancel(context.Background())
+
+	c := &netCard{
+		name:      iface.Name,
+		desc:      iface.Description,
+		iface:     netIface,
+		handle:    handle,
+		vlan:      parseVLAN(netIface.Name),
+		cancelFun: cancelFun,
+		ctx:       ctx,
+	}
+
+	n.openLock.Lock()
+	if n.cards == nil {
+		n.cards = make(map[string]*netCard)
+	}
+
+	n.cards[c.name] = c
+	n.openLock.Unlock()
+
+	n.lock.Lock()
+	if n.ipinfos == nil {
+		n.ipinfos = make(map[string]IpInfo)
+	}
+	n.lock.Unlock()
+
+	go n.capture(c)
+
 	return nil
 }
 
-func (n *NetProto) Close() {
+func (n *NetProto) capture(c *netCard) {
+	ps := gopacket.NewPacketSource(c.handle, c.handle.LinkType())
+	packets := ps.Packets()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case p, ok := <-packets:
+			if !ok {
+				return
+			}
+
+			n.handlePacket(c, p)
+		}
+	}
+}
+
+func (n *NetProto) handlePacket(c *netCard, p gopacket.Packet) {
+	src := pktSource{card: c, vlan: c.packetVLAN(p)}
+
+	//处理netproto
+	func() {
+		pkg := p.Layer(layers.LayerTypeARP)
+		if pkg == nil {
+			return
+		}
+
+		netproto := pkg.(*layers.ARP)
+		if netproto == nil {
+			return
+		}
+
+		if netproto.Operation != layers.ARPReply {
+			return
+		}
+
+		n.learn(src, IpInfo{
+			IP:  netproto.SourceProtAddress,
+			Mac: netproto.SourceHwAddress,
+		})
+	}()
+
+	//处理icmpv6
+	n.handleICMPv6(src, p)
+
+	//被动发现
+	n.passive(src, p)
+
+	//处理icmp
+	func() {
+		pkg := p.Layer(layers.LayerTypeICMPv4)
+		if pkg == nil {
+			return
+		}
+
+		icmp := pkg.(*layers.ICMPv4)
+		if icmp == nil {
+			return
+		}
+
+		if icmp.TypeCode != layers.ICMPv4TypeEchoReply {
+			return
+		}
+
+		pkg = p.Layer(layers.LayerTypeEthernet)
+		eth := pkg.(*layers.Ethernet)
+		pkg = p.Layer(layers.LayerTypeIPv4)
+		ipLayer := pkg.(*layers.IPv4)
+
+		n.handleEchoReply(p, ipLayer.SrcIP.To4().String(), eth.SrcMAC.String(), icmp.Id, icmp.Seq, icmp.Payload)
+
+		tfuns := n.copyPingFuns()
+
+		if len(tfuns) == 0 {
+			return
+		}
+
+		go func(ip, mac string) {
+			for _, fun := range tfuns {
+				fun(ip, mac)
+			}
+		}(ipLayer.SrcIP.To4().String(), eth.SrcMAC.String())
+	}()
+}
+
+// 关闭指定网卡，并移除在该网卡上发现的机器
+func (n *NetProto) CloseCard(name string) {
 	n.openLock.Lock()
-	defer n.openLock.Unlock()
+	c, ok := n.cards[name]
+	if ok {
+		c.close()
+		delete(n.cards, name)
+	}
+	n.openLock.Unlock()
 
-	if n.cancelFun != nil {
-		n.cancelFun()
-		<-n.ctx.Done()
-		n.cancelFun = nil
+	if !ok {
+		return
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
 
-		if n.handle != nil {
-			n.handle.Close()
-			n.handle = nil
+	for k, v := range n.ipinfos {
+		if v.Iface == name {
+			delete(n.ipinfos, k)
 		}
 	}
+}
+
+// 关闭所有网卡
+func (n *NetProto) Close() {
+	n.openLock.Lock()
+	for _, c := range n.cards {
+		c.close()
+	}
+
+	n.cards = make(map[string]*netCard)
+	n.openLock.Unlock()
 
 	n.lock.Lock()
 	defer n.lock.Unlock()
 	n.ipinfos = make(map[string]IpInfo)
+	n.ipv6Seen = make(map[string]map[string]time.Time)
 }
 
 func (n *NetProto) QueryIP(ip string) error {
-	addrs, err := n.iface.Addrs()
-	if err != nil {
-		return err
-	}
-
 	tIP := net.ParseIP(ip)
+	if tIP == nil {
+		return errors.New("ip is invalid")
+	}
 
-	for _, addr := range addrs {
-		srcIPNet := addr.(*net.IPNet)
-		if !srcIPNet.Contains(tIP) {
+	for _, c := range n.getCards() {
+		srcIPNet := c.netOf(tIP)
+		if srcIPNet == nil || srcIPNet.IP.To4() == nil {
 			continue
 		}
 
-		pkg, err := n.makeNetProtoPkg(n.iface.HardwareAddr, srcIPNet.IP, tIP)
+		pkg, err := n.makeNetProtoPkg(c.iface.HardwareAddr, srcIPNet.IP, tIP)
 		if err != nil {
 			return err
 		}
 
-		err = n.handle.WritePacketData(pkg)
+		err = c.write(pkg)
 		if err != nil {
 			return err
 		}
@@ -368,71 +550,101 @@ func (n *NetProto) QueryIP(ip string) error {
 }
 
 func (n *NetProto) PingNet(ips []string) error {
-	addrs, err := n.iface.Addrs()
-	if err != nil {
-		return err
-	}
+	return n.pingNet(ips, n.nextPingSeq())
+}
+
+func (n *NetProto) pingNet(ips []string, seq uint16) error {
+	ip4s := []net.IP{}
+	ip6s := []net.IP{}
+	routed := []net.IP{}
+	for _, ip := range ips {
+		ipObj := net.ParseIP(ip)
+		if ipObj == nil {
+			continue
+		}
 
-	for _, addr := range addrs {
-		srcIPNet := addr.(*net.IPNet)
-		if srcIPNet.IP.To4() == nil {
+		//网卡网段外的地址通过系统协议栈探测
+		if !n.isLocalIP(ipObj) {
+			routed = append(routed, ipObj)
 			continue
 		}
 
-		for _, ip := range ips {
-			ipObj := net.ParseIP(ip)
-			pkg, err := n.makePingPkg(n.iface.HardwareAddr, srcIPNet.IP, ipObj)
+		if ipObj.To4() != nil {
+			ip4s = append(ip4s, ipObj)
+		} else {
+			ip6s = append(ip6s, ipObj)
+		}
+	}
+
+	for _, ipObj := range ip4s {
+		for _, c := range n.cardsForIP(ipObj) {
+			srcIP := c.ipv4Src(ipObj)
+			if srcIP == nil {
+				continue
+			}
+
+			pkg, err := n.makePingPkg(c.iface.HardwareAddr, srcIP, ipObj, seq)
 			if err != nil {
 				return err
 			}
 
-			err = n.handle.WritePacketData(pkg)
+			err = c.write(pkg)
 			if err != nil {
 				return err
 			}
 		}
 	}
 
-	return nil
+	n.pingRouted(routed, seq)
+
+	return n.pingNet6(ip6s, seq)
 }
 
 func (n *NetProto) QueryNet(millisecond int) error {
-	addrs, err := n.iface.Addrs()
-	if err != nil {
-		return err
-	}
-
-	for _, addr := range addrs {
-		srcIPNet := addr.(*net.IPNet)
-		if srcIPNet.IP.To4() == nil {
-			continue
-		}
-
-		ips := comm.MakeIPs(*srcIPNet)
-		for _, ip := range ips {
-			pkg, err := n.makeNetProtoPkg(n.iface.HardwareAddr, srcIPNet.IP, ip)
-			if err != nil {
-				return err
-			}
+	for _, c := range n.getCards() {
+		for _, srcIPNet := range c.ipv4Nets() {
+			ips := comm.MakeIPs(*srcIPNet)
+			for _, ip := range ips {
+				pkg, err := n.makeNetProtoPkg(c.iface.HardwareAddr, srcIPNet.IP, ip)
+				if err != nil {
+					return err
+				}
 
-			err = n.handle.WritePacketData(pkg)
-			if err != nil {
-				return err
-			}
+				err = c.write(pkg)
+				if err != nil {
+					return err
+				}
 
-			if millisecond != 0 {
-				time.Sleep(time.Duration(millisecond) * time.Millisecond)
+				if millisecond != 0 {
+					time.Sleep(time.Duration(millisecond) * time.Millisecond)
+				}
 			}
 		}
 	}
 
-	return nil
+	return n.QueryNet6(millisecond)
 }
 
 func (n *NetProto) GetResult() map[string]IpInfo {
 	n.lock.Lock()
 	defer n.lock.Unlock()
-	return n.ipinfos
+
+	//清除过期的IPv6地址
+	now := time.Now()
+	for mac := range n.ipv6Seen {
+		if info, ok := n.ipinfos[mac]; ok {
+			info.IPv6 = n.ipv6List(mac, now)
+			n.ipinfos[mac] = info
+		}
+	}
+
+	//返回副本，抓包协程会持续写入ipinfos
+	infos := make(map[string]IpInfo, len(n.ipinfos))
+	for mac, info := range n.ipinfos {
+		infos[mac] = info
+	}
+
+	return infos
 }
 
 var netprotoOnce sync.Once
@@ -440,7 +652,12 @@ var netprotoObj *NetProto
 
 func NetProtoObj() *NetProto {
 	netprotoOnce.Do(func() {
-		netprotoObj = &NetProto{}
+		netprotoObj = &NetProto{
+			cards:     make(map[string]*netCard),
+			ipinfos:   make(map[string]IpInfo),
+			hostnames: make(map[string]string),
+			ipv6Seen:  make(map[string]map[string]time.Time),
+		}
 	})
 
 	return netprotoObj
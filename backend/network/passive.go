@@ -0,0 +1,267 @@
+package network
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"wakelan/backend/db"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+const (
+	mdnsPort    = 5353
+	netbiosPort = 137
+)
+
+// 记录发现的机器
+func (n *NetProto) learn(src pktSource, info IpInfo) {
+	if info.IP.To4() == nil || info.IP.IsUnspecified() || len(info.Mac) == 0 || n.isLocalMac(info.Mac) {
+		return
+	}
+
+	mac := info.Mac.String()
+	info.IP = info.IP.To4()
+	info.MANUF = Search(mac)
+	info.Iface = src.card.name
+	info.VLAN = src.vlan
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if len(info.Hostname) == 0 {
+		info.Hostname = n.hostnames[mac]
+	}
+
+	if old, ok := n.ipinfos[mac]; ok {
+		info.IPv6 = old.IPv6
+		info.IPv6Learned = old.IPv6Learned
+	}
+
+	n.ipinfos[mac] = info
+
+	for _, fun := range n.arpFuns {
+		go fun(info)
+	}
+}
+
+// 记录机器名称，同时更新已保存的机器
+func (n *NetProto) learnHostname(mac net.HardwareAddr, hostname string) {
+	hostname = strings.TrimSpace(strings.TrimSuffix(hostname, "."))
+	if len(hostname) == 0 || len(mac) == 0 {
+		return
+	}
+
+	strMac := mac.String()
+
+	n.lock.Lock()
+	if n.hostnames[strMac] == hostname {
+		n.lock.Unlock()
+		return
+	}
+
+	n.hostnames[strMac] = hostname
+	if info, ok := n.ipinfos[strMac]; ok {
+		info.Hostname = hostname
+		n.ipinfos[strMac] = info
+	}
+	n.lock.Unlock()
+
+	go func() {
+		dbObj := db.DBOperObj().GetDB()
+		dbObj.Model(&db.MacInfo{}).Where("mac=?", strMac).Update("hostname", hostname)
+	}()
+}
+
+// 被动发现：ARP请求、DHCP、mDNS、NetBIOS广播
+func (n *NetProto) passive(src pktSource, p gopacket.Packet) {
+	if pkg := p.Layer(layers.LayerTypeARP); pkg != nil {
+		n.passiveARP(src, pkg.(*layers.ARP))
+		return
+	}
+
+	if pkg := p.Layer(layers.LayerTypeDHCPv4); pkg != nil {
+		n.passiveDHCP(src, pkg.(*layers.DHCPv4))
+		return
+	}
+
+	pkg := p.Layer(layers.LayerTypeUDP)
+	if pkg == nil {
+		return
+	}
+
+	udp := pkg.(*layers.UDP)
+
+	ethPkg := p.Layer(layers.LayerTypeEthernet)
+	ipPkg := p.Layer(layers.LayerTypeIPv4)
+	if ethPkg == nil || ipPkg == nil {
+		return
+	}
+
+	eth := ethPkg.(*layers.Ethernet)
+	ipLayer := ipPkg.(*layers.IPv4)
+
+	switch {
+	case udp.SrcPort == mdnsPort && udp.DstPort == mdnsPort:
+		n.passiveMDNS(src, eth.SrcMAC, ipLayer.SrcIP, udp.Payload)
+	case udp.SrcPort == netbiosPort && udp.DstPort == netbiosPort:
+		n.passiveNetBIOS(src, eth.SrcMAC, ipLayer.SrcIP, udp.Payload)
+	}
+}
+
+// ARP请求和免费ARP
+func (n *NetProto) passiveARP(src pktSource, arp *layers.ARP) {
+	if arp.Operation != layers.ARPRequest {
+		return
+	}
+
+	//ARP探测的源地址为0.0.0.0
+	ip := net.IP(arp.SourceProtAddress)
+	if ip.IsUnspecified() {
+		return
+	}
+
+	n.learn(src, IpInfo{
+		IP:  append(net.IP{}, ip...),
+		Mac: append(net.HardwareAddr{}, arp.SourceHwAddress...),
+	})
+}
+
+// DHCP请求，选项12为主机名
+func (n *NetProto) passiveDHCP(src pktSource, dhcp *layers.DHCPv4) {
+	if dhcp.Operation != layers.DHCPOpRequest {
+		return
+	}
+
+	mac := append(net.HardwareAddr{}, dhcp.ClientHWAddr...)
+	ip := net.IP{}
+	if !dhcp.ClientIP.IsUnspecified() {
+		ip = append(ip, dhcp.ClientIP...)
+	}
+
+	hostname := ""
+	for _, opt := range dhcp.Options {
+		switch opt.Type {
+		case layers.DHCPOptHostname:
+			hostname = string(opt.Data)
+		case layers.DHCPOptRequestIP:
+			if len(ip) == 0 && len(opt.Data) == 4 {
+				ip = append(ip, opt.Data...)
+			}
+		}
+	}
+
+	n.learnHostname(mac, hostname)
+
+	if len(ip) != 0 {
+		n.learn(src, IpInfo{IP: ip, Mac: mac})
+	}
+}
+
+// mDNS通告，取与源地址一致的A记录
+func (n *NetProto) passiveMDNS(src pktSource, mac net.HardwareAddr, srcIP net.IP, payload []byte) {
+	dns := &layers.DNS{}
+	err := dns.DecodeFromBytes(payload, gopacket.NilDecodeFeedback)
+	if err != nil || !dns.QR {
+		return
+	}
+
+	records := append(dns.Answers, dns.Additionals...)
+	for _, r := range records {
+		if r.Type != layers.DNSTypeA || !r.IP.Equal(srcIP) {
+			continue
+		}
+
+		name := strings.TrimSuffix(strings.TrimSuffix(string(r.Name), "."), ".local")
+		n.learnHostname(append(net.HardwareAddr{}, mac...), name)
+		n.learn(src, IpInfo{
+			IP:  append(net.IP{}, srcIP...),
+			Mac: append(net.HardwareAddr{}, mac...),
+		})
+
+		return
+	}
+}
+
+// 解码NetBIOS一级编码名称，返回名称和后缀
+func decodeNetBIOSName(data []byte) (string, byte, int) {
+	if len(data) < 34 || data[0] != 32 {
+		return "", 0, 0
+	}
+
+	name := make([]byte, 16)
+	for i := 0; i < 16; i++ {
+		h := data[1+i*2] - 'A'
+		l := data[2+i*2] - 'A'
+		if h > 15 || l > 15 {
+			return "", 0, 0
+		}
+
+		name[i] = h<<4 | l
+	}
+
+	//名称长度 + 编码名称 + 结束符
+	return strings.TrimRight(string(name[:15]), " \x00"), name[15], 34
+}
+
+// NetBIOS名称注册广播
+func (n *NetProto) passiveNetBIOS(src pktSource, mac net.HardwareAddr, srcIP net.IP, payload []byte) {
+	if len(payload) < 12 {
+		return
+	}
+
+	flags := binary.BigEndian.Uint16(payload[2:4])
+	opcode := (flags >> 11) & 0x0f
+
+	//5:注册 8:刷新 9:刷新(备用)
+	if flags&0x8000 != 0 || (opcode != 5 && opcode != 8 && opcode != 9) {
+		return
+	}
+
+	if binary.BigEndian.Uint16(payload[4:6]) == 0 {
+		return
+	}
+
+	name, suffix, size := decodeNetBIOSName(payload[12:])
+	if len(name) == 0 || (suffix != 0x00 && suffix != 0x20) {
+		return
+	}
+
+	//问题记录之后为附加记录：名称（压缩指针或完整名称）、类型、类、TTL、数据长度、NB_FLAGS
+	if binary.BigEndian.Uint16(payload[10:12]) == 0 {
+		return
+	}
+
+	off := 12 + size + 4
+	if off >= len(payload) {
+		return
+	}
+
+	if payload[off]&0xc0 == 0xc0 {
+		off += 2
+	} else {
+		_, _, rrSize := decodeNetBIOSName(payload[off:])
+		if rrSize == 0 {
+			return
+		}
+
+		off += rrSize
+	}
+
+	off += 10
+	if off+2 > len(payload) {
+		return
+	}
+
+	//组名称（如工作组）不是机器名称
+	if binary.BigEndian.Uint16(payload[off:off+2])&0x8000 != 0 {
+		return
+	}
+
+	n.learnHostname(append(net.HardwareAddr{}, mac...), name)
+	n.learn(src, IpInfo{
+		IP:  append(net.IP{}, srcIP...),
+		Mac: append(net.HardwareAddr{}, mac...),
+	})
+}
@@ -0,0 +1,356 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket"
+)
+
+const (
+	pingMaxCount   = 1000
+	pingMaxTargets = 256
+)
+
+// 回显请求数据：标识 + 发送时间 + 填充
+var echoMagic = []byte("WLAN")
+
+const echoPayloadLen = 32
+
+// 带序号和往返时间的回显应答
+type EchoReply struct {
+	IP  string
+	Mac string
+	Seq uint16
+	RTT time.Duration
+}
+
+type EchoRetFun func(reply EchoReply)
+
+func (n *NetProto) AddEchoRetFun(flag string, fun EchoRetFun) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.echoFuns[flag] = fun
+}
+
+func (n *NetProto) DelEchoRetFun(flag string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	delete(n.echoFuns, flag)
+}
+
+func (n *NetProto) copyEchoFuns() map[string]EchoRetFun {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	funs := make(map[string]EchoRetFun, len(n.echoFuns))
+	for k, v := range n.echoFuns {
+		funs[k] = v
+	}
+
+	return funs
+}
+
+func (n *NetProto) nextPingSeq() uint16 {
+	return uint16(atomic.AddUint32(&n.pingSeq, 1))
+}
+
+func makeEchoPayload(t time.Time) []byte {
+	data := make([]byte, echoPayloadLen)
+	copy(data, echoMagic)
+	binary.BigEndian.PutUint64(data[len(echoMagic):], uint64(t.UnixNano()))
+	copy(data[len(echoMagic)+8:], "abcdefghijklmnopqrstuvw")
+
+	return data
+}
+
+// 解析本程序发出的回显请求数据，返回发送时间
+func parseEchoPayload(data []byte) (time.Time, bool) {
+	if len(data) < len(echoMagic)+8 || !bytes.Equal(data[:len(echoMagic)], echoMagic) {
+		return time.Time{}, false
+	}
+
+	nano := binary.BigEndian.Uint64(data[len(echoMagic):])
+
+	return time.Unix(0, int64(nano)), true
+}
+
+// 处理回显应答，计算往返时间
+func (n *NetProto) handleEchoReply(p gopacket.Packet, ip string, mac string, id uint16, seq uint16, payload []byte) {
+	if id != uint16(os.Getpid()) {
+		return
+	}
+
+	sent, ok := parseEchoPayload(payload)
+	if !ok {
+		return
+	}
+
+	//优先使用抓包时间，减少处理延迟的影响
+	recv := p.Metadata().Timestamp
+	if recv.IsZero() {
+		recv = time.Now()
+	}
+
+	rtt := recv.Sub(sent)
+	if rtt < 0 {
+		rtt = 0
+	}
+
+	tfuns := n.copyEchoFuns()
+	if len(tfuns) == 0 {
+		return
+	}
+
+	reply := EchoReply{
+		IP:  ip,
+		Mac: mac,
+		Seq: seq,
+		RTT: rtt,
+	}
+
+	go func() {
+		for _, fun := range tfuns {
+			fun(reply)
+		}
+	}()
+}
+
+// 延迟测量参数
+type PingOptions struct {
+	Count    int           //测量次数，监控模式下为统计窗口大小
+	Interval time.Duration //发送间隔
+	Timeout  time.Duration //超过该时间未应答视为丢包
+	Monitor  bool          //持续测量，直到取消
+}
+
+// 延迟统计，时间单位为毫秒
+type PingStats struct {
+	IP       string  `json:"ip"`
+	Mac      string  `json:"mac"`
+	Sent     int     `json:"sent"`
+	Received int     `json:"received"`
+	Loss     float64 `json:"loss"` //丢包率，百分比
+	Last     float64 `json:"last"`
+	Min      float64 `json:"min"`
+	Avg      float64 `json:"avg"`
+	Max      float64 `json:"max"`
+	Jitter   float64 `json:"jitter"` //相邻两次往返时间差的平均值
+	Done     bool    `json:"done"`
+}
+
+type pingProbe struct {
+	seq     uint16
+	sent    time.Time
+	rtt     time.Duration
+	replied bool
+}
+
+type pingTarget struct {
+	ip     string
+	mac    string
+	last   time.Duration
+	probes []pingProbe
+}
+
+// 统计已应答或已超时的探测
+func (t *pingTarget) stats(now time.Time, timeout time.Duration) PingStats {
+	stats := PingStats{
+		IP:  t.ip,
+		Mac: t.mac,
+	}
+
+	rtts := []float64{}
+	for _, v := range t.probes {
+		if !v.replied && now.Sub(v.sent) < timeout {
+			continue
+		}
+
+		stats.Sent++
+		if v.replied {
+			rtts = append(rtts, float64(v.rtt.Microseconds())/1000)
+		}
+	}
+
+	stats.Received = len(rtts)
+	stats.Last = float64(t.last.Microseconds()) / 1000
+
+	if stats.Sent != 0 {
+		stats.Loss = float64(stats.Sent-stats.Received) * 100 / float64(stats.Sent)
+	}
+
+	if len(rtts) == 0 {
+		return stats
+	}
+
+	stats.Min = math.Inf(1)
+	sum := 0.0
+	diff := 0.0
+	for i, v := range rtts {
+		sum += v
+		stats.Min = math.Min(stats.Min, v)
+		stats.Max = math.Max(stats.Max, v)
+
+		if i > 0 {
+			diff += math.Abs(v - rtts[i-1])
+		}
+	}
+
+	stats.Avg = sum / float64(len(rtts))
+	if len(rtts) > 1 {
+		stats.Jitter = diff / float64(len(rtts)-1)
+	}
+
+	return stats
+}
+
+// 测量往返时间和丢包率，每次收到应答和每轮发送后通过fun返回统计
+func (n *NetProto) Measure(ctx context.Context, ips []string, opt PingOptions, fun func(PingStats)) error {
+	if opt.Count <= 0 || opt.Count > pingMaxCount {
+		return fmt.Errorf("count must be 1-%d", pingMaxCount)
+	}
+
+	if opt.Interval < 100*time.Millisecond {
+		opt.Interval = 100 * time.Millisecond
+	}
+
+	if opt.Timeout <= 0 {
+		opt.Timeout = 2 * time.Second
+	}
+
+	lock := sync.Mutex{}
+	targets := map[string]*pingTarget{}
+	dsts := []string{}
+
+	for _, ip := range ips {
+		ipObj := net.ParseIP(ip)
+		if ipObj == nil {
+			continue
+		}
+
+		key := ipObj.String()
+		if _, ok := targets[key]; ok {
+			continue
+		}
+
+		targets[key] = &pingTarget{ip: key}
+		dsts = append(dsts, key)
+	}
+
+	if len(dsts) == 0 {
+		return errors.New("ip is invalid")
+	}
+
+	if len(dsts) > pingMaxTargets {
+		return fmt.Errorf("at most %d ips", pingMaxTargets)
+	}
+
+	report := func(done bool) {
+		now := time.Now()
+		stats := []PingStats{}
+
+		lock.Lock()
+		for _, ip := range dsts {
+			s := targets[ip].stats(now, opt.Timeout)
+			s.Done = done
+			stats = append(stats, s)
+		}
+		lock.Unlock()
+
+		for _, s := range stats {
+			fun(s)
+		}
+	}
+
+	flag := fmt.Sprintf("measure-%p", &targets)
+	n.AddEchoRetFun(flag, func(reply EchoReply) {
+		lock.Lock()
+		t, ok := targets[reply.IP]
+		if !ok {
+			lock.Unlock()
+			return
+		}
+
+		matched := false
+		for i := range t.probes {
+			if t.probes[i].seq == reply.Seq && !t.probes[i].replied {
+				t.probes[i].replied = true
+				t.probes[i].rtt = reply.RTT
+				matched = true
+				break
+			}
+		}
+
+		if matched {
+			t.mac = reply.Mac
+			t.last = reply.RTT
+		}
+
+		var stats PingStats
+		if matched {
+			stats = t.stats(time.Now(), opt.Timeout)
+		}
+		lock.Unlock()
+
+		if matched {
+			fun(stats)
+		}
+	})
+
+	defer n.DelEchoRetFun(flag)
+
+	ticker := time.NewTicker(opt.Interval)
+	defer ticker.Stop()
+
+	for i := 0; opt.Monitor || i < opt.Count; i++ {
+		seq := n.nextPingSeq()
+		now := time.Now()
+
+		lock.Lock()
+		for _, ip := range dsts {
+			t := targets[ip]
+			t.probes = append(t.probes, pingProbe{seq: seq, sent: now})
+
+			//监控模式只保留最近的探测
+			if len(t.probes) > opt.Count {
+				t.probes = t.probes[len(t.probes)-opt.Count:]
+			}
+		}
+		lock.Unlock()
+
+		err := n.pingNet(dsts, seq)
+		if err != nil {
+			return err
+		}
+
+		if i != 0 {
+			report(false)
+		}
+
+		select {
+		case <-ctx.Done():
+			report(true)
+			return nil
+		case <-ticker.C:
+		}
+	}
+
+	//等待最后的应答
+	select {
+	case <-ctx.Done():
+	case <-time.After(opt.Timeout):
+	}
+
+	report(true)
+
+	return nil
+}
@@ -0,0 +1,208 @@
+package network
+
+import (
+	"strings"
+	"sync"
+	"time"
+	"wakelan/backend/db"
+)
+
+// 连续多少个探测周期无响应判定为离线
+const presenceOfflineRounds = 3
+
+// 在线状态跟踪：周期探测已知机器，记录上下线变化
+type PresenceTracker struct {
+	lock     sync.Mutex
+	lastSeen map[string]time.Time
+}
+
+func (p *PresenceTracker) Start() {
+	//重启后按保存的最近发现时间继续计算，不会把在线机器立即判定为离线
+	presences := []db.Presence{}
+	db.DBOperObj().GetDB().Where("online=?", true).Find(&presences)
+
+	p.lock.Lock()
+	for _, v := range presences {
+		p.lastSeen[v.Mac] = v.LastSeen
+	}
+	p.lock.Unlock()
+
+	n := NetProtoObj()
+
+	n.AddArpRetFun("presence", func(info IpInfo) {
+		p.Seen(info.Mac.String())
+	})
+
+	n.AddPingRetFun("presence", func(ip string, mac string) {
+		//跨网段探测的机器没有保存记录时无MAC
+		if len(mac) != 0 {
+			p.Seen(mac)
+		}
+	})
+
+	go func() {
+		lastClean := time.Time{}
+
+		for {
+			interval := db.DBOperObj().GetConfig().PresenceInterval
+			if interval <= 0 {
+				time.Sleep(time.Minute)
+				continue
+			}
+
+			if interval < 10 {
+				interval = 10
+			}
+
+			p.probe()
+
+			//等待响应后再判断离线
+			time.Sleep(time.Duration(interval) * time.Second)
+			p.check(time.Duration(interval) * time.Second)
+
+			if time.Since(lastClean) > 24*time.Hour {
+				lastClean = time.Now()
+				p.clean()
+			}
+		}
+	}()
+}
+
+// 记录收到机器响应
+func (p *PresenceTracker) Seen(mac string) {
+	mac = strings.ToLower(mac)
+	now := time.Now()
+
+	p.lock.Lock()
+	_, ok := p.lastSeen[mac]
+	p.lastSeen[mac] = now
+	p.lock.Unlock()
+
+	if ok {
+		return
+	}
+
+	//首次收到响应时立即更新状态，之后在周期检查时批量保存
+	p.update(mac, now, true)
+}
+
+func (p *PresenceTracker) probe() {
+	n := NetProtoObj()
+	if !n.IsOpen() {
+		return
+	}
+
+	macInfos := []db.MacInfo{}
+	db.DBOperObj().GetDB().Find(&macInfos)
+
+	ips := []string{}
+	for _, info := range macInfos {
+		if len(info.IP) == 0 {
+			continue
+		}
+
+		n.QueryIP(info.IP)
+		ips = append(ips, info.IP)
+	}
+
+	if len(ips) != 0 {
+		n.PingNet(ips)
+	}
+}
+
+func (p *PresenceTracker) check(interval time.Duration) {
+	if !NetProtoObj().IsOpen() {
+		return
+	}
+
+	now := time.Now()
+
+	p.lock.Lock()
+	seen := make(map[string]time.Time, len(p.lastSeen))
+	for k, v := range p.lastSeen {
+		seen[k] = v
+	}
+	p.lock.Unlock()
+
+	presences := []db.Presence{}
+	db.DBOperObj().GetDB().Where("online=?", true).Find(&presences)
+
+	online := map[string]bool{}
+	for _, v := range presences {
+		online[v.Mac] = true
+	}
+
+	for mac, t := range seen {
+		if now.Sub(t) <= interval*presenceOfflineRounds {
+			p.update(mac, t, true)
+			delete(online, mac)
+		}
+	}
+
+	//超时未响应的机器标记为离线
+	for mac := range online {
+		p.update(mac, now, false)
+
+		p.lock.Lock()
+		delete(p.lastSeen, mac)
+		p.lock.Unlock()
+	}
+}
+
+// 更新在线状态，状态变化时写入记录
+func (p *PresenceTracker) update(mac string, t time.Time, isOnline bool) {
+	dbObj := db.DBOperObj().GetDB()
+
+	info := &db.Presence{}
+	result := dbObj.Where("mac=?", mac).Limit(1).Find(info)
+	if result.Error != nil {
+		return
+	}
+
+	isNew := result.RowsAffected == 0
+	if isNew {
+		if !isOnline {
+			return
+		}
+
+		info.Mac = mac
+		info.FirstSeen = t
+	}
+
+	changed := isNew || info.Online != isOnline
+
+	info.Online = isOnline
+	if isOnline {
+		info.LastSeen = t
+	}
+
+	if changed {
+		info.Changed = t
+		dbObj.Create(&db.PresenceEvent{
+			Mac:    mac,
+			Online: isOnline,
+			Time:   t,
+		})
+	}
+
+	dbObj.Save(info)
+}
+
+// 清理过期的上下线记录
+func (p *PresenceTracker) clean() {
+	dbObj := db.DBOperObj().GetDB()
+	dbObj.Where("time<?", time.Now().AddDate(0, 0, -90)).Delete(&db.PresenceEvent{})
+}
+
+var presenceOnce sync.Once
+var presenceObj *PresenceTracker
+
+func PresenceObj() *PresenceTracker {
+	presenceOnce.Do(func() {
+		presenceObj = &PresenceTracker{
+			lastSeen: make(map[string]time.Time),
+		}
+	})
+
+	return presenceObj
+}
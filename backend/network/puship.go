@@ -45,16 +45,7 @@ func (p *PushIP) Start(second int) error {
 						db.DBLog("消息推送", "公网IP %s", p.ip)
 					}
 
-					err := errors.New("no match")
-
-					if len(info.AYFFToken) != 0 {
-						err = comm.AYFFPushMsg(msg, info.AYFFToken)
-					}
-
-					if len(info.WXPusherToken) != 0 && info.WXPusherTopicId != 0 {
-						err = comm.WXPusherMsg(msg, info.WXPusherToken, info.WXPusherTopicId)
-					}
-
+					err := pushMsg(info, msg)
 					if err != nil {
 						isPrintLog = true
 						db.DBLog("消息推送", "推送失败 %s", err.Error())
@@ -76,6 +67,59 @@ func (p *PushIP) Start(second int) error {
 	return nil
 }
 
+// 通过已配置的渠道推送消息
+func pushMsg(info *db.GlobalInfo, msg string) error {
+	err := errors.New("no match")
+
+	if len(info.AYFFToken) != 0 {
+		err = comm.AYFFPushMsg(msg, info.AYFFToken)
+	}
+
+	if len(info.WXPusherToken) != 0 && info.WXPusherTopicId != 0 {
+		err = comm.WXPusherMsg(msg, info.WXPusherToken, info.WXPusherTopicId)
+	}
+
+	return err
+}
+
+// 推送消息，未配置推送渠道时忽略
+func PushMsg(msg string) error {
+	cfg := db.DBOperObj().GetConfig()
+	if len(cfg.AYFFToken) == 0 && len(cfg.WXPusherToken) == 0 {
+		return nil
+	}
+
+	return pushMsg(cfg, msg)
+}
+
+// 推送队列长度，超过后丢弃新消息
+const pushQueueSize = 64
+
+var pushQueueOnce sync.Once
+var pushQueue chan string
+
+// 异步推送消息，由同一个协程按顺序发送，不阻塞调用者
+func PushMsgAsync(msg string) {
+	pushQueueOnce.Do(func() {
+		pushQueue = make(chan string, pushQueueSize)
+
+		go func() {
+			for msg := range pushQueue {
+				err := PushMsg(msg)
+				if err != nil {
+					db.DBLog("消息推送", "推送失败 %s", err.Error())
+				}
+			}
+		}()
+	})
+
+	select {
+	case pushQueue <- msg:
+	default:
+		db.DBLog("消息推送", "推送队列已满，丢弃消息：%s", msg)
+	}
+}
+
 func (p *PushIP) GetIP() string {
 	return p.ip
 }
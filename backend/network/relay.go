@@ -0,0 +1,312 @@
+package network
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"wakelan/backend/db"
+)
+
+const (
+	magicPacketLen = 102
+	relayTimeLen   = 8
+	relayHMACLen   = sha256.Size
+
+	relayTimeWindow = 60 * time.Second //HMAC时间戳允许误差
+	relaySrcLimit   = 10               //每个来源每分钟最多包数
+	relayMacLimit   = 5 * time.Second  //同一MAC转发最小间隔
+)
+
+// 唤醒中继：监听UDP端口，验证后在当前网卡上重新广播
+// 支持两种认证方式：
+//  1. SecureOn：魔术包 + 4/6字节密码，需与机器保存的密码一致
+//  2. HMAC：魔术包 + 8字节时间戳(大端，秒) + HMAC-SHA256(机器中继密钥，魔术包+时间戳)
+type WakeRelay struct {
+	conn     *net.UDPConn
+	lock     sync.Mutex
+	srcCount map[string][]time.Time
+	macLast  map[string]time.Time
+	macStamp map[string]int64
+	logLast  map[string]time.Time
+}
+
+func (r *WakeRelay) IsRunning() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.conn != nil
+}
+
+func (r *WakeRelay) Start(port int) error {
+	r.Stop()
+
+	if port <= 0 || port > 65535 {
+		return errors.New("relay port error")
+	}
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
+	if err != nil {
+		return err
+	}
+
+	//只重新绑定端口，保留限速和HMAC时间戳记录，重启后不能重放已使用的包
+	r.lock.Lock()
+	r.conn = conn
+	r.lock.Unlock()
+
+	db.DBLog("唤醒中继", "启动，端口：%d", port)
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+
+			r.handle(addr, append([]byte{}, buf[:n]...))
+		}
+	}()
+
+	return nil
+}
+
+func (r *WakeRelay) Stop() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.conn != nil {
+		r.conn.Close()
+		r.conn = nil
+		db.DBLog("唤醒中继", "停止")
+	}
+}
+
+// 按配置启动或停止
+func (r *WakeRelay) Reload() error {
+	cfg := db.DBOperObj().GetConfig()
+	if !cfg.RelayEnable {
+		r.Stop()
+		return nil
+	}
+
+	return r.Start(cfg.RelayPort)
+}
+
+// 来源限速
+func (r *WakeRelay) allowSrc(src string, now time.Time) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	times := []time.Time{}
+	for _, t := range r.srcCount[src] {
+		if now.Sub(t) < time.Minute {
+			times = append(times, t)
+		}
+	}
+
+	if len(times) >= relaySrcLimit {
+		r.srcCount[src] = times
+		return false
+	}
+
+	r.srcCount[src] = append(times, now)
+
+	//清理过期来源
+	if len(r.srcCount) > 1024 {
+		for k, v := range r.srcCount {
+			if len(v) == 0 || now.Sub(v[len(v)-1]) >= time.Minute {
+				delete(r.srcCount, k)
+				delete(r.logLast, k)
+			}
+		}
+	}
+
+	return true
+}
+
+// 拒绝日志每个来源每分钟最多记录一次
+func (r *WakeRelay) logReject(src string, format string, a ...any) {
+	r.lock.Lock()
+	last, ok := r.logLast[src]
+	now := time.Now()
+	if ok && now.Sub(last) < time.Minute {
+		r.lock.Unlock()
+		return
+	}
+
+	r.logLast[src] = now
+	r.lock.Unlock()
+
+	db.DBLog("唤醒中继", "拒绝，来源：%s，"+format, append([]any{src}, a...)...)
+}
+
+// 解析魔术包中的MAC
+func parseMagicPacket(data []byte) (net.HardwareAddr, error) {
+	if len(data) < magicPacketLen {
+		return nil, errors.New("packet too short")
+	}
+
+	if !bytes.Equal(data[0:6], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}) {
+		return nil, errors.New("sync stream error")
+	}
+
+	mac := data[6:12]
+	for i := 1; i < 16; i++ {
+		if !bytes.Equal(data[6+i*6:12+i*6], mac) {
+			return nil, errors.New("mac repeat error")
+		}
+	}
+
+	return net.HardwareAddr(append([]byte{}, mac...)), nil
+}
+
+// 校验HMAC后缀，返回时间戳
+func checkRelayHMAC(data []byte, key []byte, now time.Time) (int64, error) {
+	if len(data) != magicPacketLen+relayTimeLen+relayHMACLen {
+		return 0, errors.New("hmac length error")
+	}
+
+	body := data[:magicPacketLen+relayTimeLen]
+	stamp := int64(binary.BigEndian.Uint64(data[magicPacketLen:]))
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), data[magicPacketLen+relayTimeLen:]) {
+		return 0, errors.New("hmac mismatch")
+	}
+
+	diff := now.Sub(time.Unix(stamp, 0))
+	if diff > relayTimeWindow || diff < -relayTimeWindow {
+		return 0, errors.New("timestamp expired")
+	}
+
+	return stamp, nil
+}
+
+func (r *WakeRelay) handle(addr *net.UDPAddr, data []byte) {
+	src := addr.IP.String()
+	now := time.Now()
+
+	if !r.allowSrc(src, now) {
+		r.logReject(src, "超过频率限制")
+		return
+	}
+
+	mac, err := parseMagicPacket(data)
+	if err != nil {
+		r.logReject(src, "无效数据包：%s", err.Error())
+		return
+	}
+
+	strMac := mac.String()
+
+	param, err := LoadWakeParam(strMac)
+	if err != nil {
+		r.logReject(src, "Mac：%s，%s", strMac, err.Error())
+		return
+	}
+
+	attach := &db.AttachInfo{}
+	db.DBOperObj().GetDB().Where("mac=?", strMac).Find(attach)
+
+	auth := ""
+	var stamp int64
+
+	switch {
+	case len(data) == magicPacketLen+4 || len(data) == magicPacketLen+6:
+		if len(param.Password) == 0 || !hmac.Equal(param.Password, data[magicPacketLen:]) {
+			r.logReject(src, "Mac：%s，SecureOn密码错误", strMac)
+			return
+		}
+
+		auth = "SecureOn"
+	case len(data) == magicPacketLen+relayTimeLen+relayHMACLen:
+		key, err := hex.DecodeString(attach.RelayKey)
+		if err != nil || len(key) == 0 {
+			r.logReject(src, "Mac：%s，未配置中继密钥", strMac)
+			return
+		}
+
+		stamp, err = checkRelayHMAC(data, key, now)
+		if err != nil {
+			r.logReject(src, "Mac：%s，%s", strMac, err.Error())
+			return
+		}
+
+		auth = "HMAC"
+	default:
+		r.logReject(src, "Mac：%s，未认证", strMac)
+		return
+	}
+
+	r.lock.Lock()
+	//防重放：HMAC时间戳必须递增，相同时间戳视为同一批重复包
+	lastStamp := r.macStamp[strMac]
+	if stamp != 0 && stamp <= lastStamp {
+		r.lock.Unlock()
+		if stamp < lastStamp {
+			r.logReject(src, "Mac：%s，重放的数据包", strMac)
+		}
+		return
+	}
+
+	if stamp != 0 {
+		r.macStamp[strMac] = stamp
+	}
+
+	//客户端通常连续发送多个包，间隔内只转发一次
+	if now.Sub(r.macLast[strMac]) < relayMacLimit {
+		r.lock.Unlock()
+		return
+	}
+
+	r.macLast[strMac] = now
+	r.lock.Unlock()
+
+	err = NetProtoObj().WakeLan(strMac, WakeParam{
+		Mode:     WakeBroadcast,
+		Password: param.Password,
+	})
+
+	if err != nil {
+		db.DBLog("唤醒中继", "转发失败，来源：%s，Mac：%s，认证：%s，错误：%s", src, strMac, auth, err.Error())
+		return
+	}
+
+	db.DBLog("唤醒中继", "转发成功，来源：%s，Mac：%s，认证：%s", src, strMac, auth)
+}
+
+// 生成机器中继密钥
+func GenRelayKey() (string, error) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.ToUpper(hex.EncodeToString(key)), nil
+}
+
+var wakeRelayOnce sync.Once
+var wakeRelayObj *WakeRelay
+
+func WakeRelayObj() *WakeRelay {
+	wakeRelayOnce.Do(func() {
+		wakeRelayObj = &WakeRelay{
+			srcCount: make(map[string][]time.Time),
+			macLast:  make(map[string]time.Time),
+			macStamp: make(map[string]int64),
+			logLast:  make(map[string]time.Time),
+		}
+	})
+
+	return wakeRelayObj
+}
@@ -0,0 +1,278 @@
+package network
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+const (
+	resolveTimeout = 2 * time.Second
+	resolveRetry   = time.Hour //解析失败后重试间隔
+	resolveWorkers = 8         //同时解析的最大数量
+)
+
+// 主机名解析：依次尝试反向DNS、NetBIOS节点状态、单播mDNS
+type HostResolver struct {
+	lock sync.Mutex
+	last map[string]time.Time
+	sem  chan struct{}
+}
+
+func (r *HostResolver) Start() {
+	NetProtoObj().AddArpRetFun("resolve", func(info IpInfo) {
+		r.Resolve(info)
+	})
+}
+
+// 解析未知名称的机器，结果写入机器信息
+func (r *HostResolver) Resolve(info IpInfo) {
+	if len(info.Hostname) != 0 || info.IP.To4() == nil {
+		return
+	}
+
+	mac := info.Mac.String()
+	now := time.Now()
+
+	r.lock.Lock()
+	if now.Sub(r.last[mac]) < resolveRetry {
+		r.lock.Unlock()
+		return
+	}
+
+	//解析数量已满时丢弃，等下次收到该机器数据时再解析
+	select {
+	case r.sem <- struct{}{}:
+	default:
+		r.lock.Unlock()
+		return
+	}
+
+	r.last[mac] = now
+
+	//清理过期记录
+	if len(r.last) > 1024 {
+		for k, v := range r.last {
+			if now.Sub(v) >= resolveRetry {
+				delete(r.last, k)
+			}
+		}
+	}
+	r.lock.Unlock()
+
+	go func() {
+		defer func() { <-r.sem }()
+
+		name, err := ResolveHostname(info.IP)
+		if err != nil {
+			return
+		}
+
+		NetProtoObj().learnHostname(info.Mac, name)
+	}()
+}
+
+// 解析IP对应的主机名
+func ResolveHostname(ip net.IP) (string, error) {
+	funs := []func(net.IP) (string, error){
+		lookupReverseDNS,
+		lookupNetBIOS,
+		lookupMDNS,
+	}
+
+	for _, fun := range funs {
+		name, err := fun(ip)
+		if err == nil && len(name) != 0 {
+			return name, nil
+		}
+	}
+
+	return "", errors.New("no find")
+}
+
+func lookupReverseDNS(ip net.IP) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+
+	names, err := net.DefaultResolver.LookupAddr(ctx, ip.String())
+	if err != nil {
+		return "", err
+	}
+
+	if len(names) == 0 {
+		return "", errors.New("no find")
+	}
+
+	return strings.TrimSuffix(names[0], "."), nil
+}
+
+// 发送UDP请求并等待一个响应
+func udpQuery(ip net.IP, port int, data []byte) ([]byte, error) {
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: ip, Port: port})
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(resolveTimeout))
+
+	_, err = conn.Write(data)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 1500)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf[:n], nil
+}
+
+// NetBIOS一级编码
+func encodeNetBIOSName(name string, suffix byte) []byte {
+	raw := make([]byte, 16)
+	for i := range raw[:15] {
+		raw[i] = ' '
+	}
+
+	//通配名称使用0填充
+	if name == "*" {
+		for i := range raw {
+			raw[i] = 0
+		}
+	}
+
+	copy(raw, name)
+	raw[15] = suffix
+
+	data := []byte{32}
+	for _, v := range raw {
+		data = append(data, 'A'+(v>>4), 'A'+(v&0x0f))
+	}
+
+	return append(data, 0)
+}
+
+// NetBIOS节点状态查询，取第一个唯一的工作站名称
+func lookupNetBIOS(ip net.IP) (string, error) {
+	req := make([]byte, 12)
+	binary.BigEndian.PutUint16(req[0:], uint16(rand.Intn(0xffff)))
+	binary.BigEndian.PutUint16(req[4:], 1)
+	req = append(req, encodeNetBIOSName("*", 0)...)
+	req = append(req, 0x00, 0x21, 0x00, 0x01) //NBSTAT IN
+
+	resp, err := udpQuery(ip, netbiosPort, req)
+	if err != nil {
+		return "", err
+	}
+
+	if len(resp) < 12 || binary.BigEndian.Uint16(resp[6:8]) == 0 {
+		return "", errors.New("no answer")
+	}
+
+	//跳过名称
+	pos := 12
+	if pos < len(resp) && resp[pos]&0xc0 == 0xc0 {
+		pos += 2
+	} else {
+		for pos < len(resp) && resp[pos] != 0 {
+			pos += int(resp[pos]) + 1
+		}
+		pos++
+	}
+
+	//类型 + 类 + TTL + 长度
+	pos += 10
+	if pos >= len(resp) {
+		return "", errors.New("packet too short")
+	}
+
+	count := int(resp[pos])
+	pos++
+
+	for i := 0; i < count && pos+18 <= len(resp); i++ {
+		entry := resp[pos : pos+18]
+		pos += 18
+
+		flags := binary.BigEndian.Uint16(entry[16:18])
+		if entry[15] != 0x00 || flags&0x8000 != 0 {
+			continue
+		}
+
+		name := strings.TrimRight(string(entry[:15]), " \x00")
+		if len(name) != 0 {
+			return name, nil
+		}
+	}
+
+	return "", errors.New("no find")
+}
+
+// 单播mDNS反向查询，源端口非5353时应答方直接单播回复
+func lookupMDNS(ip net.IP) (string, error) {
+	ip4 := ip.To4()
+	arpa := fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa", ip4[3], ip4[2], ip4[1], ip4[0])
+
+	query := &layers.DNS{
+		ID:      uint16(rand.Intn(0xffff)),
+		QDCount: 1,
+		Questions: []layers.DNSQuestion{{
+			Name:  []byte(arpa),
+			Type:  layers.DNSTypePTR,
+			Class: layers.DNSClassIN,
+		}},
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	err := query.SerializeTo(buf, gopacket.SerializeOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := udpQuery(ip4, mdnsPort, buf.Bytes())
+	if err != nil {
+		return "", err
+	}
+
+	dns := &layers.DNS{}
+	err = dns.DecodeFromBytes(resp, gopacket.NilDecodeFeedback)
+	if err != nil {
+		return "", err
+	}
+
+	for _, r := range dns.Answers {
+		if r.Type != layers.DNSTypePTR || len(r.PTR) == 0 {
+			continue
+		}
+
+		return strings.TrimSuffix(strings.TrimSuffix(string(r.PTR), "."), ".local"), nil
+	}
+
+	return "", errors.New("no find")
+}
+
+var hostResolverOnce sync.Once
+var hostResolverObj *HostResolver
+
+func HostResolverObj() *HostResolver {
+	hostResolverOnce.Do(func() {
+		hostResolverObj = &HostResolver{
+			last: make(map[string]time.Time),
+			sem:  make(chan struct{}, resolveWorkers),
+		}
+	})
+
+	return hostResolverObj
+}
@@ -0,0 +1,258 @@
+package network
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"wakelan/backend/db"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	routedTimeout = 2 * time.Second
+	routedWorkers = 32 //同时探测的地址数
+)
+
+// ICMP不可用时TCP连接的端口，连接被拒绝也说明机器在线
+var routedPorts = []int{80, 443, 22, 445, 3389, 139, 5900}
+
+var routedSem = make(chan struct{}, routedWorkers)
+
+// 是否在已打开网卡的网段内，网段外的机器无法通过以太网帧和ARP探测
+func (n *NetProto) isLocalIP(ip net.IP) bool {
+	for _, c := range n.getCards() {
+		if c.netOf(ip) != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// 跨网段探测：通过系统协议栈发送ICMP或TCP连接，应答时回调pingFuns和echoFuns
+// 取得并发名额后再启动探测协程，地址多时不会堆积大量等待的协程
+func (n *NetProto) pingRouted(ips []net.IP, seq uint16) {
+	if len(ips) == 0 {
+		return
+	}
+
+	go func() {
+		for _, ip := range ips {
+			routedSem <- struct{}{}
+
+			go func(ip net.IP) {
+				defer func() { <-routedSem }()
+
+				n.reachRouted(ip, seq)
+			}(ip)
+		}
+	}()
+}
+
+func (n *NetProto) reachRouted(ip net.IP, seq uint16) {
+	rtt, err := Reach(ip, seq, routedTimeout)
+	if err != nil {
+		return
+	}
+
+	strIP := ip.String()
+	if ip.To4() != nil {
+		strIP = ip.To4().String()
+	}
+
+	mac := routedMac(strIP)
+
+	for _, fun := range n.copyPingFuns() {
+		fun(strIP, mac)
+	}
+
+	reply := EchoReply{
+		IP:  strIP,
+		Mac: mac,
+		Seq: seq,
+		RTT: rtt,
+	}
+
+	for _, fun := range n.copyEchoFuns() {
+		fun(reply)
+	}
+}
+
+// 跨网段的机器无法获取MAC，使用已保存的记录
+func routedMac(ip string) string {
+	info := &db.MacInfo{}
+	dbObj := db.DBOperObj().GetDB()
+
+	result := dbObj.Where("ip=?", ip).Limit(1).Find(info)
+	if result.Error == nil && result.RowsAffected != 0 {
+		return info.Mac
+	}
+
+	infos := []db.MacInfo{}
+	dbObj.Where("ipv6 like ?", "%"+ip+"%").Find(&infos)
+	for _, v := range infos {
+		for _, ip6 := range strings.Split(v.IPv6, ",") {
+			if ip6 == ip {
+				return v.Mac
+			}
+		}
+	}
+
+	return ""
+}
+
+// 检测地址是否可达，返回往返时间：先使用ICMP，失败时使用TCP连接
+func Reach(ip net.IP, seq uint16, timeout time.Duration) (time.Duration, error) {
+	rtt, err := icmpReach(ip, seq, timeout)
+	if err == nil {
+		return rtt, nil
+	}
+
+	return tcpReach(ip, timeout)
+}
+
+// ICMP回显，优先使用无需权限的ICMP套接字，其次为原始套接字
+func icmpReach(ip net.IP, seq uint16, timeout time.Duration) (time.Duration, error) {
+	type sockType struct {
+		network string
+		addr    string
+		udp     bool
+	}
+
+	proto := 1
+	var reqType icmp.Type = ipv4.ICMPTypeEcho
+	var replyType icmp.Type = ipv4.ICMPTypeEchoReply
+	types := []sockType{{"udp4", "0.0.0.0", true}, {"ip4:icmp", "0.0.0.0", false}}
+
+	if ip.To4() == nil {
+		proto = 58
+		reqType = ipv6.ICMPTypeEchoRequest
+		replyType = ipv6.ICMPTypeEchoReply
+		types = []sockType{{"udp6", "::", true}, {"ip6:ipv6-icmp", "::", false}}
+	}
+
+	var conn *icmp.PacketConn
+	var err error
+	var sock sockType
+	for _, sock = range types {
+		conn, err = icmp.ListenPacket(sock.network, sock.addr)
+		if err == nil {
+			break
+		}
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer conn.Close()
+
+	id := os.Getpid() & 0xffff
+	msg := icmp.Message{
+		Type: reqType,
+		Body: &icmp.Echo{
+			ID:   id,
+			Seq:  int(seq),
+			Data: makeEchoPayload(time.Now()),
+		},
+	}
+
+	data, err := msg.Marshal(nil)
+	if err != nil {
+		return 0, err
+	}
+
+	var dst net.Addr = &net.IPAddr{IP: ip}
+	if sock.udp {
+		dst = &net.UDPAddr{IP: ip}
+	}
+
+	begin := time.Now()
+	conn.SetDeadline(begin.Add(timeout))
+
+	_, err = conn.WriteTo(data, dst)
+	if err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		size, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			return 0, err
+		}
+
+		reply, err := icmp.ParseMessage(proto, buf[:size])
+		if err != nil || reply.Type != replyType {
+			continue
+		}
+
+		echo, ok := reply.Body.(*icmp.Echo)
+		if !ok || echo.Seq != int(seq) {
+			continue
+		}
+
+		//ICMP套接字由内核分配标识，原始套接字需要校验标识和来源
+		if !sock.udp && (echo.ID != id || !addrIP(peer).Equal(ip)) {
+			continue
+		}
+
+		return time.Since(begin), nil
+	}
+}
+
+func addrIP(addr net.Addr) net.IP {
+	switch v := addr.(type) {
+	case *net.IPAddr:
+		return v.IP
+	case *net.UDPAddr:
+		return v.IP
+	}
+
+	return nil
+}
+
+// TCP连接常用端口，连接成功或被拒绝都说明机器在线
+func tcpReach(ip net.IP, timeout time.Duration) (time.Duration, error) {
+	type result struct {
+		rtt time.Duration
+		err error
+	}
+
+	ch := make(chan result, len(routedPorts))
+	begin := time.Now()
+
+	for _, port := range routedPorts {
+		go func(port int) {
+			conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip.String(), strconv.Itoa(port)), timeout)
+			if err == nil {
+				conn.Close()
+			} else if isRefused(err) {
+				err = nil
+			}
+
+			ch <- result{time.Since(begin), err}
+		}(port)
+	}
+
+	err := errors.New("unreachable")
+	for range routedPorts {
+		ret := <-ch
+		if ret.err == nil {
+			return ret.rtt, nil
+		}
+	}
+
+	return 0, err
+}
+
+func isRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
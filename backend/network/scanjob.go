@@ -0,0 +1,448 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"wakelan/backend/comm"
+	"wakelan/backend/db"
+)
+
+// 扫描任务状态
+const (
+	ScanJobRunning   = "running"
+	ScanJobDone      = "done"
+	ScanJobCancelled = "cancelled"
+	ScanJobError     = "error"
+)
+
+const (
+	scanDefaultRate = 200                    //默认每秒发包数
+	scanMaxRate     = 5000                   //最大每秒发包数
+	scanMaxHosts    = 65536                  //单次扫描最大地址数
+	scanWaitReply   = 3 * time.Second        //发送完成后等待响应
+	scanNotifyGap   = 500 * time.Millisecond //进度推送间隔
+)
+
+type ScanDevice struct {
+	Mac   string `json:"mac"`
+	IP    string `json:"ip"`
+	OldIP string `json:"old_ip,omitempty"`
+	MANUF string `json:"manuf"`
+}
+
+// 扫描结果：新增、IP变化、消失的机器
+type ScanSummary struct {
+	New      []ScanDevice `json:"new"`
+	Changed  []ScanDevice `json:"changed"`
+	Vanished []ScanDevice `json:"vanished"`
+}
+
+type ScanJobInfo struct {
+	ID      string       `json:"id"`
+	CIDR    string       `json:"cidr"`
+	Rate    int          `json:"rate"`
+	Total   int          `json:"total"`
+	Sent    int          `json:"sent"`
+	Replies int          `json:"replies"`
+	ETA     int64        `json:"eta"`     //预计剩余秒数
+	Elapsed int64        `json:"elapsed"` //毫秒
+	State   string       `json:"state"`
+	Err     string       `json:"err"`
+	Summary *ScanSummary `json:"summary,omitempty"`
+}
+
+func (i ScanJobInfo) IsDone() bool {
+	return i.State != ScanJobRunning
+}
+
+type scanTarget struct {
+	card *netCard
+	src  net.IP
+	dst  net.IP
+}
+
+type scanJob struct {
+	jobBase[ScanJobInfo]
+	info       ScanJobInfo
+	begin      time.Time
+	cancel     chan struct{}
+	onceCancel sync.Once
+	nets       []*net.IPNet
+	found      map[string]IpInfo
+}
+
+func (j *scanJob) isCancelled() bool {
+	select {
+	case <-j.cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+// 当前状态，lock需已锁定
+func (j *scanJob) snapshot() ScanJobInfo {
+	j.info.Replies = len(j.found)
+	j.info.Elapsed = time.Since(j.begin).Milliseconds()
+
+	j.info.ETA = 0
+	if !j.info.IsDone() {
+		j.info.ETA = int64((j.info.Total-j.info.Sent)/j.info.Rate) + int64(scanWaitReply.Seconds())
+	}
+
+	return j.info
+}
+
+func (j *scanJob) notify() {
+	j.update(j.snapshot)
+}
+
+type ScanJobManager struct {
+	jobManager[ScanJobInfo, *scanJob]
+	running string
+	lock    sync.Mutex
+}
+
+// 解析扫描范围，cidr为空时扫描网卡所在的所有IPv4网段，多个网段以逗号分隔
+func (s *ScanJobManager) makeTargets(cidr string) ([]scanTarget, []*net.IPNet, error) {
+	cards := NetProtoObj().getCards()
+	localNets, err := cardsIPv4Nets(cards)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	scanNets := []*net.IPNet{}
+	if len(strings.TrimSpace(cidr)) == 0 {
+		scanNets = localNets
+	} else {
+		for _, v := range strings.Split(cidr, ",") {
+			_, ipNet, err := net.ParseCIDR(strings.TrimSpace(v))
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if ipNet.IP.To4() == nil {
+				return nil, nil, errors.New("only ipv4 cidr is supported")
+			}
+
+			scanNets = append(scanNets, ipNet)
+		}
+	}
+
+	targets := []scanTarget{}
+	for _, scanNet := range scanNets {
+		var card *netCard
+		var src net.IP
+		for _, c := range cards {
+			if localNet := c.netOf(scanNet.IP.Mask(scanNet.Mask)); localNet != nil && localNet.IP.To4() != nil {
+				card = c
+				src = localNet.IP
+				break
+			}
+		}
+
+		if src == nil {
+			return nil, nil, errors.New(scanNet.String() + " is not on the interface")
+		}
+
+		ones, bits := scanNet.Mask.Size()
+		if len(targets)+(1<<(bits-ones)) > scanMaxHosts {
+			return nil, nil, errors.New("too many hosts")
+		}
+
+		for _, ip := range comm.MakeIPs(*scanNet) {
+			if ip.Equal(src) {
+				continue
+			}
+
+			targets = append(targets, scanTarget{card: card, src: src, dst: ip})
+		}
+	}
+
+	return targets, scanNets, nil
+}
+
+// 启动扫描任务，rate为每秒发包数
+func (s *ScanJobManager) Start(cidr string, rate int) (string, error) {
+	if !NetProtoObj().IsOpen() {
+		return "", errors.New("network not open")
+	}
+
+	if rate <= 0 {
+		rate = scanDefaultRate
+	}
+
+	if rate > scanMaxRate {
+		rate = scanMaxRate
+	}
+
+	targets, nets, err := s.makeTargets(cidr)
+	if err != nil {
+		return "", err
+	}
+
+	//未指定范围时同时探测IPv6，计入进度
+	withIPv6 := len(strings.TrimSpace(cidr)) == 0
+	if withIPv6 {
+		targets = append(targets, NetProtoObj().ipv6Targets()...)
+	}
+
+	strNets := []string{}
+	for _, v := range nets {
+		strNets = append(strNets, v.String())
+	}
+
+	job := &scanJob{
+		info: ScanJobInfo{
+			ID:    comm.GenRandKey(),
+			CIDR:  strings.Join(strNets, ","),
+			Rate:  rate,
+			Total: len(targets),
+			State: ScanJobRunning,
+		},
+		begin:  time.Now(),
+		cancel: make(chan struct{}),
+		nets:   nets,
+		found:  make(map[string]IpInfo),
+	}
+
+	s.lock.Lock()
+	if len(s.running) != 0 {
+		s.lock.Unlock()
+		return "", errors.New("scan job is running")
+	}
+
+	s.running = job.info.ID
+	s.add(job.info.ID, job)
+	s.lock.Unlock()
+
+	go s.run(job, targets, withIPv6)
+
+	return job.info.ID, nil
+}
+
+func (s *ScanJobManager) run(job *scanJob, targets []scanTarget, withIPv6 bool) {
+	n := NetProtoObj()
+	flag := "scanjob_" + job.info.ID
+
+	defer s.finish(job.info.ID)
+
+	//扫描前的机器，用于对比结果
+	baseline := map[string]db.MacInfo{}
+	macInfos := []db.MacInfo{}
+	db.DBOperObj().GetDB().Find(&macInfos)
+	for _, v := range macInfos {
+		baseline[v.Mac] = v
+	}
+
+	n.AddArpRetFun(flag, func(info IpInfo) {
+		job.lock.Lock()
+		defer job.lock.Unlock()
+
+		for _, ipNet := range job.nets {
+			if ipNet.Contains(info.IP) {
+				job.found[info.Mac.String()] = info
+				return
+			}
+		}
+	})
+	defer n.DelArpRetFun(flag)
+
+	db.DBLog("扫描网络", "开始，范围：%s，速率：%d包/秒，地址数：%d", job.info.CIDR, job.info.Rate, job.info.Total)
+
+	job.notify()
+
+	sent := map[string]bool{}
+	interval := time.Second / time.Duration(job.info.Rate)
+	ticker := time.NewTicker(interval)
+	lastNotify := time.Now()
+	var err error
+
+	if withIPv6 {
+		err = n.pingAllNodes6()
+	}
+
+Send_Fin:
+	for _, target := range targets {
+		if err != nil {
+			break
+		}
+
+		select {
+		case <-job.cancel:
+			break Send_Fin
+		case <-ticker.C:
+		}
+
+		if target.dst.To4() == nil {
+			err = n.solicit(target.card, target.src, target.dst)
+		} else {
+			var pkg []byte
+			pkg, err = n.makeNetProtoPkg(target.card.iface.HardwareAddr, target.src, target.dst)
+			if err == nil {
+				err = target.card.write(pkg)
+			}
+		}
+
+		if err != nil {
+			break
+		}
+
+		sent[target.dst.String()] = true
+
+		job.lock.Lock()
+		job.info.Sent++
+		job.lock.Unlock()
+
+		if time.Since(lastNotify) >= scanNotifyGap {
+			lastNotify = time.Now()
+			job.notify()
+		}
+	}
+
+	ticker.Stop()
+
+	//等待响应
+	if err == nil && !job.isCancelled() {
+		wait := time.NewTimer(scanWaitReply)
+		select {
+		case <-wait.C:
+		case <-job.cancel:
+			wait.Stop()
+		}
+	}
+
+	n.DelArpRetFun(flag)
+
+	job.lock.Lock()
+	summary := s.makeSummary(baseline, job.found, sent)
+	job.info.Summary = summary
+
+	switch {
+	case err != nil:
+		job.info.State = ScanJobError
+		job.info.Err = err.Error()
+	case job.isCancelled():
+		job.info.State = ScanJobCancelled
+	default:
+		job.info.State = ScanJobDone
+	}
+
+	info := job.snapshot()
+	job.lock.Unlock()
+
+	s.save(info, job.begin)
+
+	job.notify()
+
+	db.DBLog("扫描网络", "结束，范围：%s，状态：%s，已发送：%d，响应：%d，新增：%d，变化：%d，消失：%d",
+		info.CIDR, info.State, info.Sent, info.Replies, len(summary.New), len(summary.Changed), len(summary.Vanished))
+}
+
+// 对比扫描前后的机器，消失的机器只统计已发送探测的地址
+func (s *ScanJobManager) makeSummary(baseline map[string]db.MacInfo, found map[string]IpInfo, sent map[string]bool) *ScanSummary {
+	summary := &ScanSummary{
+		New:      []ScanDevice{},
+		Changed:  []ScanDevice{},
+		Vanished: []ScanDevice{},
+	}
+
+	for mac, info := range found {
+		dev := ScanDevice{
+			Mac:   mac,
+			IP:    info.IP.String(),
+			MANUF: info.MANUF,
+		}
+
+		old, ok := baseline[mac]
+		if !ok {
+			summary.New = append(summary.New, dev)
+			continue
+		}
+
+		if len(old.IP) != 0 && old.IP != dev.IP {
+			dev.OldIP = old.IP
+			summary.Changed = append(summary.Changed, dev)
+		}
+	}
+
+	for mac, old := range baseline {
+		if _, ok := found[mac]; ok || !sent[old.IP] {
+			continue
+		}
+
+		summary.Vanished = append(summary.Vanished, ScanDevice{
+			Mac:   mac,
+			IP:    old.IP,
+			MANUF: old.MANUF,
+		})
+	}
+
+	return summary
+}
+
+func (s *ScanJobManager) save(info ScanJobInfo, begin time.Time) {
+	detail, _ := json.Marshal(info.Summary)
+
+	db.DBOperObj().GetDB().Create(&db.ScanRecord{
+		ID:       info.ID,
+		CIDR:     info.CIDR,
+		Rate:     info.Rate,
+		Total:    info.Total,
+		Sent:     info.Sent,
+		Replies:  info.Replies,
+		State:    info.State,
+		Begin:    begin,
+		End:      time.Now(),
+		New:      len(info.Summary.New),
+		Changed:  len(info.Summary.Changed),
+		Vanished: len(info.Summary.Vanished),
+		Detail:   string(detail),
+	})
+}
+
+// 任务结束，允许开始新的扫描
+func (s *ScanJobManager) finish(id string) {
+	s.lock.Lock()
+	if s.running == id {
+		s.running = ""
+	}
+	s.lock.Unlock()
+
+	s.remove(id)
+}
+
+func (s *ScanJobManager) Cancel(id string) error {
+	job, ok := s.get(id)
+	if !ok {
+		return errors.New("job not found")
+	}
+
+	job.onceCancel.Do(func() {
+		close(job.cancel)
+	})
+
+	return nil
+}
+
+// 获取正在运行的任务ID
+func (s *ScanJobManager) Running() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.running
+}
+
+var scanJobOnce sync.Once
+var scanJobObj *ScanJobManager
+
+func ScanJobMG() *ScanJobManager {
+	scanJobOnce.Do(func() {
+		scanJobObj = &ScanJobManager{}
+	})
+
+	return scanJobObj
+}
@@ -0,0 +1,450 @@
+package network
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"wakelan/backend/db"
+)
+
+const (
+	serviceTimeout = 2 * time.Second
+	serviceRetry   = 24 * time.Hour //自动探测同一机器的间隔
+	serviceWorkers = 4              //同时探测的机器数
+	serviceMaxPort = 64             //自定义端口最大数量，不含默认端口
+)
+
+// 默认探测的端口
+var defaultServicePorts = []int{22, 23, 3389, 5900, 80, 443}
+
+// 远程连接类型，与前端guacd配置一致：0:rdp 1:vnc 2:ssh 3:telnet 4:http
+const (
+	RemoteRDP = iota
+	RemoteVNC
+	RemoteSSH
+	RemoteTelnet
+	RemoteHTTP
+)
+
+// 服务探测：TCP连接常用端口并读取简单的服务标识
+type ServiceProber struct {
+	lock   sync.Mutex
+	last   map[string]time.Time
+	sem    chan struct{}
+	enable atomic.Bool //自动探测开关，避免每个ARP包都查询配置
+}
+
+func (s *ServiceProber) Start() {
+	s.Reload()
+
+	NetProtoObj().AddArpRetFun("service", func(info IpInfo) {
+		if !s.enable.Load() {
+			return
+		}
+
+		mac := info.Mac.String()
+		now := time.Now()
+
+		s.lock.Lock()
+		if now.Sub(s.last[mac]) < serviceRetry {
+			s.lock.Unlock()
+			return
+		}
+
+		//探测任务已满时丢弃，之后收到ARP时再探测
+		select {
+		case s.sem <- struct{}{}:
+		default:
+			s.lock.Unlock()
+			return
+		}
+
+		s.last[mac] = now
+		s.lock.Unlock()
+
+		go func() {
+			defer func() { <-s.sem }()
+
+			s.Probe(mac, info.IP.String(), nil)
+		}()
+	})
+}
+
+// 重新加载自动探测开关，修改配置后调用
+func (s *ServiceProber) Reload() {
+	s.enable.Store(db.DBOperObj().GetConfig().ServiceProbe)
+}
+
+// 解析端口列表，格式：22,80,8000-8010
+func ParsePorts(str string) ([]int, error) {
+	ports := []int{}
+
+	for _, v := range strings.Split(str, ",") {
+		v = strings.TrimSpace(v)
+		if len(v) == 0 {
+			continue
+		}
+
+		begin, end, found := strings.Cut(v, "-")
+		if !found {
+			end = begin
+		}
+
+		b, err := strconv.Atoi(strings.TrimSpace(begin))
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := strconv.Atoi(strings.TrimSpace(end))
+		if err != nil {
+			return nil, err
+		}
+
+		if b <= 0 || e > 65535 || b > e {
+			return nil, errors.New("port out of range: " + v)
+		}
+
+		if len(ports)+e-b+1 > serviceMaxPort {
+			return nil, fmt.Errorf("too many ports, max %d", serviceMaxPort)
+		}
+
+		for p := b; p <= e; p++ {
+			ports = append(ports, p)
+		}
+	}
+
+	return ports, nil
+}
+
+// 默认端口在前，之后为配置的自定义端口和指定端口，去重后只限制自定义端口的数量
+func servicePorts(extra []int) []int {
+	cfgPorts, err := ParsePorts(db.DBOperObj().GetConfig().ServicePorts)
+	if err == nil {
+		extra = append(cfgPorts, extra...)
+	}
+
+	ports := []int{}
+	exists := map[int]bool{}
+	for _, p := range defaultServicePorts {
+		exists[p] = true
+		ports = append(ports, p)
+	}
+
+	extras := []int{}
+	for _, p := range extra {
+		if !exists[p] {
+			exists[p] = true
+			extras = append(extras, p)
+		}
+	}
+
+	sort.Ints(extras)
+
+	if len(extras) > serviceMaxPort {
+		extras = extras[:serviceMaxPort]
+	}
+
+	return append(ports, extras...)
+}
+
+// 探测机器服务，保存结果，未配置远程连接时按探测结果预填
+func (s *ServiceProber) Probe(mac string, ip string, extra []int) ([]db.Service, error) {
+	mac = strings.ToLower(mac)
+
+	if net.ParseIP(ip) == nil {
+		return nil, errors.New("ip is invalid")
+	}
+
+	ports := servicePorts(extra)
+
+	lock := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	services := []db.Service{}
+	now := time.Now()
+
+	for _, port := range ports {
+		wg.Add(1)
+		go func(port int) {
+			defer wg.Done()
+
+			name, banner, err := probePort(ip, port)
+			if err != nil {
+				return
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			services = append(services, db.Service{
+				Mac:    mac,
+				Port:   port,
+				Name:   name,
+				Banner: banner,
+				Time:   now,
+			})
+		}(port)
+	}
+
+	wg.Wait()
+
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Port < services[j].Port
+	})
+
+	dbObj := db.DBOperObj().GetDB()
+	dbObj.Where("mac=?", mac).Delete(&db.Service{})
+	if len(services) != 0 {
+		result := dbObj.Create(&services)
+		if result.Error != nil {
+			return services, result.Error
+		}
+	}
+
+	s.prefillRemote(mac, ip, services)
+
+	names := []string{}
+	for _, v := range services {
+		names = append(names, fmt.Sprintf("%d/%s", v.Port, v.Name))
+	}
+
+	db.DBLog("服务探测", "Mac：%s，IP：%s，服务：%s", mac, ip, strings.Join(names, ","))
+
+	return services, nil
+}
+
+// 按探测结果选择远程连接类型和端口，优先级：RDP、VNC、SSH、Telnet、HTTP
+func RemoteTypeOf(services []db.Service) (int, int, bool) {
+	order := []string{"rdp", "vnc", "ssh", "telnet", "http", "https"}
+	types := map[string]int{
+		"rdp":    RemoteRDP,
+		"vnc":    RemoteVNC,
+		"ssh":    RemoteSSH,
+		"telnet": RemoteTelnet,
+		"http":   RemoteHTTP,
+		"https":  RemoteHTTP,
+	}
+
+	for _, name := range order {
+		for _, v := range services {
+			if v.Name == name {
+				return types[name], v.Port, true
+			}
+		}
+	}
+
+	return 0, 0, false
+}
+
+// 未配置远程连接时写入默认配置，格式与前端一致
+func (s *ServiceProber) prefillRemote(mac string, ip string, services []db.Service) {
+	t, port, ok := RemoteTypeOf(services)
+	if !ok {
+		return
+	}
+
+	dbObj := db.DBOperObj().GetDB()
+
+	info := &db.AttachInfo{}
+	dbObj.Where("mac=?", mac).Find(info)
+	if len(info.Remote) != 0 {
+		return
+	}
+
+	users := []string{"Administrator", "Administrator", "root", "Administrator", ""}
+
+	https := false
+	for _, v := range services {
+		if v.Port == port && v.Name == "https" {
+			https = true
+		}
+	}
+
+	remote := map[string]interface{}{
+		"remote": map[string]interface{}{
+			"host":  ip,
+			"port":  port,
+			"user":  users[t],
+			"pwd":   "",
+			"type":  t,
+			"path":  "",
+			"https": https,
+		},
+		"sftp": map[string]interface{}{
+			"enable":    false,
+			"up":        true,
+			"down":      true,
+			"rootPath":  "/",
+			"keepalive": 10,
+			"host":      ip,
+			"port":      22,
+			"user":      "root",
+			"pwd":       "",
+		},
+	}
+
+	data, _ := json.Marshal(remote)
+
+	info.Mac = mac
+	info.Remote = string(data)
+
+	result := dbObj.Model(info).Update("remote", info.Remote)
+	if result.Error == nil && result.RowsAffected == 0 {
+		dbObj.Save(info)
+	}
+}
+
+// 连接端口并识别服务
+func probePort(ip string, port int) (string, string, error) {
+	addr := net.JoinHostPort(ip, strconv.Itoa(port))
+
+	conn, err := net.DialTimeout("tcp", addr, serviceTimeout)
+	if err != nil {
+		return "", "", err
+	}
+
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(serviceTimeout))
+
+	switch port {
+	case 3389:
+		return "rdp", rdpBanner(conn), nil
+	case 80, 8080:
+		return "http", httpBanner(conn, ip), nil
+	case 443, 8443:
+		tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+		return "https", httpBanner(tlsConn, ip), nil
+	}
+
+	//等待服务主动发送的标识
+	banner := readBanner(conn)
+
+	switch {
+	case strings.HasPrefix(banner, "SSH-"):
+		return "ssh", banner, nil
+	case strings.HasPrefix(banner, "RFB "):
+		return "vnc", banner, nil
+	case port == 22:
+		return "ssh", banner, nil
+	case port == 23:
+		return "telnet", "", nil
+	case port == 5900:
+		return "vnc", banner, nil
+	}
+
+	return "tcp", banner, nil
+}
+
+// 读取一行可见字符
+func readBanner(conn net.Conn) string {
+	line, _ := bufio.NewReader(conn).ReadString('\n')
+	line = strings.TrimSpace(line)
+
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e {
+			return -1
+		}
+
+		return r
+	}, line)
+}
+
+// HTTP服务读取Server头
+func httpBanner(conn net.Conn, host string) string {
+	_, err := fmt.Fprintf(conn, "HEAD / HTTP/1.0\r\nHost: %s\r\n\r\n", host)
+	if err != nil {
+		return ""
+	}
+
+	reader := bufio.NewReader(conn)
+	status, err := reader.ReadString('\n')
+	if err != nil {
+		return ""
+	}
+
+	banner := strings.TrimSpace(status)
+	for {
+		line, err := reader.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if err != nil || len(line) == 0 {
+			break
+		}
+
+		if k, v, ok := strings.Cut(line, ":"); ok && strings.EqualFold(k, "server") {
+			banner = strings.TrimSpace(v)
+			break
+		}
+	}
+
+	return banner
+}
+
+// RDP协商：发送X.224连接请求，解析服务端选择的安全协议
+func rdpBanner(conn net.Conn) string {
+	//TPKT + X.224 CR + RDP_NEG_REQ(TLS|CredSSP)
+	req := []byte{
+		0x03, 0x00, 0x00, 0x13,
+		0x0e, 0xe0, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x01, 0x00, 0x08, 0x00, 0x03, 0x00, 0x00, 0x00,
+	}
+
+	_, err := conn.Write(req)
+	if err != nil {
+		return ""
+	}
+
+	resp := make([]byte, 19)
+	n, err := conn.Read(resp)
+	if err != nil || n < 11 || resp[0] != 0x03 || resp[5] != 0xd0 {
+		return ""
+	}
+
+	if n < 19 {
+		return "Standard RDP Security"
+	}
+
+	value := binary.LittleEndian.Uint32(resp[15:19])
+
+	switch resp[11] {
+	case 0x02:
+		protocols := map[uint32]string{
+			0: "Standard RDP Security",
+			1: "TLS",
+			2: "CredSSP",
+			8: "RDSTLS",
+		}
+
+		if v, ok := protocols[value]; ok {
+			return v
+		}
+
+		return fmt.Sprintf("protocol 0x%x", value)
+	case 0x03:
+		return fmt.Sprintf("negotiation failure 0x%x", value)
+	}
+
+	return ""
+}
+
+var serviceProberOnce sync.Once
+var serviceProberObj *ServiceProber
+
+func ServiceProberObj() *ServiceProber {
+	serviceProberOnce.Do(func() {
+		serviceProberObj = &ServiceProber{
+			last: make(map[string]time.Time),
+			sem:  make(chan struct{}, serviceWorkers),
+		}
+	})
+
+	return serviceProberObj
+}
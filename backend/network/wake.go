@@ -0,0 +1,308 @@
+package network
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"wakelan/backend/comm"
+	"wakelan/backend/db"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+type WakeMode int
+
+const (
+	WakeBroadcast WakeMode = iota //网卡子网定向广播
+	WakeUnicast                   //单播/中继
+	WakeRawEther                  //原始以太网帧
+)
+
+// 唤醒参数
+type WakeParam struct {
+	Mode     WakeMode
+	Target   string //单播地址或目的MAC
+	Password []byte //SecureOn密码
+}
+
+// 网络唤醒以太网类型
+const EthernetTypeWakeOnLan layers.EthernetType = 0x0842
+
+// 发送次数
+const wakeRepeat = 3
+
+func (m WakeMode) String() string {
+	switch m {
+	case WakeBroadcast:
+		return "广播"
+	case WakeUnicast:
+		return "单播"
+	case WakeRawEther:
+		return "以太网帧"
+	}
+
+	return "未知"
+}
+
+// 解析唤醒模式
+func ParseWakeMode(mode string) (WakeMode, error) {
+	switch strings.ToLower(mode) {
+	case "", "0", "broadcast":
+		return WakeBroadcast, nil
+	case "1", "unicast":
+		return WakeUnicast, nil
+	case "2", "raw":
+		return WakeRawEther, nil
+	}
+
+	return WakeBroadcast, errors.New("unknown wake mode")
+}
+
+// 获取网卡IPv4地址
+func (n *NetProto) ipv4Nets() ([]*net.IPNet, error) {
+	return cardsIPv4Nets(n.getCards())
+}
+
+func cardsIPv4Nets(cards []*netCard) ([]*net.IPNet, error) {
+	if len(cards) == 0 {
+		return []*net.IPNet{}, errors.New("network not open")
+	}
+
+	ipNets := []*net.IPNet{}
+	for _, c := range cards {
+		ipNets = append(ipNets, c.ipv4Nets()...)
+	}
+
+	if len(ipNets) == 0 {
+		return ipNets, errors.New("no ipv4 address")
+	}
+
+	return ipNets, nil
+}
+
+// 计算子网广播地址
+func broadcastIP(ipNet *net.IPNet) net.IP {
+	ip := ipNet.IP.To4()
+	mask := ipNet.Mask
+	if len(mask) == net.IPv6len {
+		mask = mask[12:]
+	}
+
+	bcast := make(net.IP, net.IPv4len)
+	for i := range ip {
+		bcast[i] = ip[i] | ^mask[i]
+	}
+
+	return bcast
+}
+
+// 从指定地址发送UDP
+func sendUDP(srcIP net.IP, dst string, data []byte) error {
+	dstAddr, err := net.ResolveUDPAddr("udp4", dst)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialUDP("udp4", &net.UDPAddr{IP: srcIP}, dstAddr)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	for i := 0; i < wakeRepeat; i++ {
+		_, err = conn.Write(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 子网定向广播
+func (n *NetProto) wakeBroadcast(cards []*netCard, pkg []byte) error {
+	ipNets, err := cardsIPv4Nets(cards)
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	sent := false
+
+	for _, ipNet := range ipNets {
+		bcast := broadcastIP(ipNet).String()
+		for _, port := range []int{7, 9} {
+			err := sendUDP(ipNet.IP, net.JoinHostPort(bcast, strconv.Itoa(port)), pkg)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+
+			sent = true
+		}
+	}
+
+	if !sent {
+		return lastErr
+	}
+
+	return nil
+}
+
+// 单播或中继，target格式：ip 或 ip:port，默认端口9
+func (n *NetProto) wakeUnicast(pkg []byte, target string) error {
+	if len(target) == 0 {
+		return errors.New("wake target is empty")
+	}
+
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		target = net.JoinHostPort(target, "9")
+	}
+
+	//网卡打开时从网卡地址发出，否则由系统路由
+	var srcIP net.IP
+	ipNets, err := n.ipv4Nets()
+	if err == nil {
+		srcIP = ipNets[0].IP
+		host, _, _ := net.SplitHostPort(target)
+		for _, ipNet := range ipNets {
+			if ipNet.Contains(net.ParseIP(host)) {
+				srcIP = ipNet.IP
+				break
+			}
+		}
+	}
+
+	return sendUDP(srcIP, target, pkg)
+}
+
+// 原始以太网帧，target为空时使用广播MAC
+// 机器在中继口上带VLAN标签时，发送的帧同样加上标签
+func (n *NetProto) wakeRawEther(cards []*netCard, vlan int, pkg []byte, target string) error {
+	if len(cards) == 0 {
+		return errors.New("network not open")
+	}
+
+	dstMac := net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	if len(target) != 0 {
+		var err error
+		dstMac, err = net.ParseMAC(target)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, c := range cards {
+		eth := layers.Ethernet{
+			SrcMAC:       c.iface.HardwareAddr,
+			DstMAC:       dstMac,
+			EthernetType: EthernetTypeWakeOnLan,
+		}
+
+		wakeLayers := []gopacket.SerializableLayer{&eth}
+		if vlan != 0 && vlan != c.vlan {
+			eth.EthernetType = layers.EthernetTypeDot1Q
+			wakeLayers = append(wakeLayers, &layers.Dot1Q{
+				VLANIdentifier: uint16(vlan),
+				Type:           EthernetTypeWakeOnLan,
+			})
+		}
+
+		wakeLayers = append(wakeLayers, gopacket.Payload(pkg))
+
+		opt := gopacket.SerializeOptions{}
+
+		buf := gopacket.NewSerializeBuffer()
+		err := gopacket.SerializeLayers(buf, opt, wakeLayers...)
+		if err != nil {
+			return err
+		}
+
+		for i := 0; i < wakeRepeat; i++ {
+			err = c.write(buf.Bytes())
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// 通过机器所在的网卡唤醒，未知时从所有打开的网卡发出
+func (n *NetProto) WakeLan(mac string, param WakeParam) error {
+	pkg, err := comm.MakeMagicPacket(mac, param.Password)
+	if err != nil {
+		return err
+	}
+
+	cards, vlan := n.cardsForMac(mac)
+
+	switch param.Mode {
+	case WakeBroadcast:
+		//未打开网卡时退回全局广播
+		if !n.IsOpen() {
+			return comm.WakeLan(mac, param.Password)
+		}
+
+		return n.wakeBroadcast(cards, pkg)
+	case WakeUnicast:
+		return n.wakeUnicast(pkg, param.Target)
+	case WakeRawEther:
+		return n.wakeRawEther(cards, vlan, pkg, param.Target)
+	}
+
+	return errors.New("unknown wake mode")
+}
+
+// 解密SecureOn密码
+func DecryptSecureOn(encPwd string) ([]byte, error) {
+	if len(encPwd) == 0 {
+		return []byte{}, nil
+	}
+
+	cfg := db.DBOperObj().GetConfig()
+	pwd, err := comm.AES_CBC_OpenBase64(encPwd, []byte(cfg.RandKey), []byte(comm.AttachIV))
+	if err != nil {
+		return []byte{}, err
+	}
+
+	return comm.ParseSecureOn(pwd)
+}
+
+// 读取机器保存的唤醒参数
+func LoadWakeParam(mac string) (WakeParam, error) {
+	param := WakeParam{}
+
+	info := &db.AttachInfo{}
+	dbObj := db.DBOperObj().GetDB()
+	result := dbObj.Where("mac=?", mac).Find(info)
+	if result.Error != nil {
+		return param, result.Error
+	}
+
+	param.Mode = WakeMode(info.WakeMode)
+	param.Target = info.WakeTarget
+
+	pwd, err := DecryptSecureOn(info.SecureOn)
+	if err != nil {
+		return param, err
+	}
+
+	param.Password = pwd
+
+	return param, nil
+}
+
+// 按机器保存的参数唤醒
+func (n *NetProto) WakeDevice(mac string) error {
+	param, err := LoadWakeParam(mac)
+	if err != nil {
+		return err
+	}
+
+	return n.WakeLan(mac, param)
+}
@@ -0,0 +1,184 @@
+package network
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"wakelan/backend/comm"
+	"wakelan/backend/db"
+)
+
+// 唤醒任务状态
+const (
+	WakeJobSent    = "sent"
+	WakeJobWaiting = "waiting"
+	WakeJobOnline  = "online"
+	WakeJobTimeout = "timeout"
+	WakeJobError   = "error"
+)
+
+type WakeJobInfo struct {
+	ID      string `json:"id"`
+	Mac     string `json:"mac"`
+	IP      string `json:"ip"`
+	State   string `json:"state"`
+	Retry   int    `json:"retry"`
+	Elapsed int64  `json:"elapsed"` //毫秒
+	Err     string `json:"err"`
+}
+
+func (i WakeJobInfo) IsDone() bool {
+	return i.State == WakeJobOnline ||
+		i.State == WakeJobTimeout ||
+		i.State == WakeJobError
+}
+
+type wakeJob struct {
+	jobBase[WakeJobInfo]
+	info     WakeJobInfo
+	online   chan struct{}
+	onceDone sync.Once
+}
+
+func (j *wakeJob) snapshot() WakeJobInfo {
+	return j.info
+}
+
+func (j *wakeJob) setState(state string, errMsg string, begin time.Time) {
+	j.update(func() WakeJobInfo {
+		j.info.State = state
+		j.info.Err = errMsg
+		j.info.Elapsed = time.Since(begin).Milliseconds()
+		return j.info
+	})
+}
+
+type WakeJobManager struct {
+	jobManager[WakeJobInfo, *wakeJob]
+}
+
+// 启动唤醒验证任务，timeout和interval为秒
+func (w *WakeJobManager) Start(mac string, ip string, timeout int, interval int) (string, error) {
+	n := NetProtoObj()
+	if !n.IsOpen() {
+		return "", errors.New("network not open")
+	}
+
+	if _, err := net.ParseMAC(mac); err != nil {
+		return "", err
+	}
+
+	//未指定IP时使用记录的IP
+	if len(ip) == 0 {
+		info := &db.MacInfo{}
+		db.DBOperObj().GetDB().Where("mac=?", mac).Find(info)
+		ip = info.IP
+	}
+
+	if net.ParseIP(ip) == nil {
+		return "", errors.New("ip is invalid")
+	}
+
+	if timeout <= 0 {
+		timeout = 180
+	}
+
+	if interval <= 0 {
+		interval = 3
+	}
+
+	job := &wakeJob{
+		info: WakeJobInfo{
+			ID:  comm.GenRandKey(),
+			Mac: mac,
+			IP:  ip,
+		},
+		online: make(chan struct{}),
+	}
+
+	w.add(job.info.ID, job)
+
+	go w.run(job, time.Duration(timeout)*time.Second, time.Duration(interval)*time.Second)
+
+	return job.info.ID, nil
+}
+
+func (w *WakeJobManager) run(job *wakeJob, timeout time.Duration, interval time.Duration) {
+	n := NetProtoObj()
+	flag := "wakejob_" + job.info.ID
+	begin := time.Now()
+
+	defer w.remove(job.info.ID)
+
+	setOnline := func() {
+		job.onceDone.Do(func() {
+			close(job.online)
+		})
+	}
+
+	n.AddPingRetFun(flag, func(ip string, mac string) {
+		if ip == job.info.IP {
+			setOnline()
+		}
+	})
+	defer n.DelPingRetFun(flag)
+
+	n.AddArpRetFun(flag, func(info IpInfo) {
+		if info.IP.Equal(net.ParseIP(job.info.IP)) || strings.EqualFold(info.Mac.String(), job.info.Mac) {
+			setOnline()
+		}
+	})
+	defer n.DelArpRetFun(flag)
+
+	err := n.WakeDevice(job.info.Mac)
+	if err != nil {
+		job.setState(WakeJobError, err.Error(), begin)
+		db.DBLog("唤醒验证", "Mac：%s，唤醒失败：%s", job.info.Mac, err.Error())
+		return
+	}
+
+	job.setState(WakeJobSent, "", begin)
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-job.online:
+			job.setState(WakeJobOnline, "", begin)
+			db.DBLog("唤醒验证", "Mac：%s，IP：%s，启动耗时：%.1f秒",
+				job.info.Mac, job.info.IP, time.Since(begin).Seconds())
+			return
+		case <-deadline.C:
+			job.setState(WakeJobTimeout, "", begin)
+			db.DBLog("唤醒验证", "Mac：%s，IP：%s，%d秒内未上线",
+				job.info.Mac, job.info.IP, int(timeout.Seconds()))
+			return
+		case <-ticker.C:
+			job.lock.Lock()
+			job.info.Retry++
+			job.lock.Unlock()
+
+			n.QueryIP(job.info.IP)
+			n.PingNet([]string{job.info.IP})
+
+			job.setState(WakeJobWaiting, "", begin)
+		}
+	}
+}
+
+var wakeJobOnce sync.Once
+var wakeJobObj *WakeJobManager
+
+func WakeJobMG() *WakeJobManager {
+	wakeJobOnce.Do(func() {
+		wakeJobObj = &WakeJobManager{}
+	})
+
+	return wakeJobObj
+}
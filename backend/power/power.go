@@ -0,0 +1,297 @@
+package power
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"wakelan/backend/comm"
+	"wakelan/backend/db"
+)
+
+// 电源动作
+const (
+	ActionShutdown = "shutdown"
+	ActionReboot   = "reboot"
+	ActionSleep    = "sleep"
+)
+
+// 执行方式
+const (
+	TypeSSH        = 0 //SSH命令
+	TypeHTTP       = 1 //HTTP回调
+	TypeWindowsSSH = 2 //Windows shutdown(SSH)
+)
+
+// 机器电源配置，保存于AttachInfo.Power
+type PowerInfo struct {
+	Type    int    `json:"type"`
+	Host    string `json:"host"` //为空时使用机器IP
+	Port    int    `json:"port"`
+	User    string `json:"user"`
+	Pwd     string `json:"pwd"` //前端加密
+	Key     string `json:"key"` //私钥，前端加密
+	HostKey string `json:"host_key"`
+
+	Shutdown string `json:"shutdown"` //命令或URL，为空时使用默认命令
+	Reboot   string `json:"reboot"`
+	Sleep    string `json:"sleep"`
+
+	Method   string `json:"method"`   //HTTP方法，默认GET
+	Body     string `json:"body"`     //HTTP请求体
+	Insecure bool   `json:"insecure"` //HTTPS不校验证书
+
+	mac string
+}
+
+var defaultCmds = map[int]map[string]string{
+	TypeSSH: {
+		ActionShutdown: "sudo -n poweroff || poweroff",
+		ActionReboot:   "sudo -n reboot || reboot",
+		ActionSleep:    "sudo -n systemctl suspend || systemctl suspend",
+	},
+	TypeWindowsSSH: {
+		ActionShutdown: "shutdown /s /f /t 0",
+		ActionReboot:   "shutdown /r /f /t 0",
+		ActionSleep:    "rundll32.exe powrprof.dll,SetSuspendState 0,1,0",
+	},
+}
+
+func (p *PowerInfo) command(action string) string {
+	cmd := ""
+	switch action {
+	case ActionShutdown:
+		cmd = p.Shutdown
+	case ActionReboot:
+		cmd = p.Reboot
+	case ActionSleep:
+		cmd = p.Sleep
+	}
+
+	if len(cmd) == 0 {
+		cmd = defaultCmds[p.Type][action]
+	}
+
+	return cmd
+}
+
+// 检查配置
+func (p *PowerInfo) Check() error {
+	switch p.Type {
+	case TypeSSH, TypeWindowsSSH:
+		if len(p.User) == 0 {
+			return errors.New("SSH用户不能为空")
+		}
+
+		if len(p.Pwd) == 0 && len(p.Key) == 0 {
+			return errors.New("SSH密码和私钥不能同时为空")
+		}
+	case TypeHTTP:
+		if len(p.Shutdown) == 0 && len(p.Reboot) == 0 && len(p.Sleep) == 0 {
+			return errors.New("HTTP回调地址不能为空")
+		}
+	default:
+		return errors.New("未知的执行方式")
+	}
+
+	return nil
+}
+
+func (p *PowerInfo) TypeName() string {
+	switch p.Type {
+	case TypeSSH:
+		return "SSH"
+	case TypeHTTP:
+		return "HTTP"
+	case TypeWindowsSSH:
+		return "Windows SSH"
+	}
+
+	return "未知"
+}
+
+func (p *PowerInfo) runHTTP(url string) error {
+	method := strings.ToUpper(p.Method)
+	if len(method) == 0 {
+		method = http.MethodGet
+	}
+
+	var body io.Reader
+	if len(p.Body) != 0 {
+		body = bytes.NewBufferString(p.Body)
+	}
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return err
+	}
+
+	if len(p.Body) != 0 && json.Valid([]byte(p.Body)) {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: p.Insecure},
+		},
+	}
+
+	rsp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		return fmt.Errorf("http status code:%d", rsp.StatusCode)
+	}
+
+	return nil
+}
+
+// 执行电源动作，pwd和key需已解密
+func (p *PowerInfo) Run(action string) error {
+	cmd := p.command(action)
+	if len(cmd) == 0 {
+		return errors.New("未配置该动作")
+	}
+
+	switch p.Type {
+	case TypeSSH, TypeWindowsSSH:
+		_, err := SSHExec(SSHConfig{
+			Host:    p.Host,
+			Port:    p.Port,
+			User:    p.User,
+			Pwd:     p.Pwd,
+			Key:     p.Key,
+			HostKey: p.HostKey,
+			OnHostKey: func(fingerprint string) error {
+				return p.pinHostKey(fingerprint)
+			},
+		}, cmd)
+
+		return err
+	case TypeHTTP:
+		return p.runHTTP(cmd)
+	}
+
+	return errors.New("未知的执行方式")
+}
+
+// 首次连接时保存主机公钥指纹，之后连接必须一致
+func (p *PowerInfo) pinHostKey(fingerprint string) error {
+	if len(p.mac) == 0 {
+		return errors.New("ssh host key not configured: " + fingerprint)
+	}
+
+	dbObj := db.DBOperObj().GetDB()
+
+	attach := &db.AttachInfo{}
+	dbObj.Where("mac=?", p.mac).Find(attach)
+
+	//只修改host_key，保留其余加密字段
+	datas := map[string]interface{}{}
+	err := json.Unmarshal([]byte(attach.Power), &datas)
+	if err != nil {
+		return err
+	}
+
+	datas["host_key"] = fingerprint
+	data, err := json.Marshal(datas)
+	if err != nil {
+		return err
+	}
+
+	result := dbObj.Model(attach).Update("power", string(data))
+	if result.Error != nil {
+		return result.Error
+	}
+
+	p.HostKey = fingerprint
+	db.DBLog("电源配置", "Mac：%s，记录SSH主机公钥：%s", p.mac, fingerprint)
+
+	return nil
+}
+
+// 读取机器电源配置并解密
+func LoadPowerInfo(mac string) (*PowerInfo, error) {
+	dbObj := db.DBOperObj().GetDB()
+
+	attach := &db.AttachInfo{}
+	dbObj.Where("mac=?", mac).Find(attach)
+
+	if len(attach.Power) == 0 {
+		return nil, errors.New("未配置电源操作")
+	}
+
+	info := &PowerInfo{}
+	err := json.Unmarshal([]byte(attach.Power), info)
+	if err != nil {
+		return nil, err
+	}
+
+	info.mac = mac
+
+	cfg := db.DBOperObj().GetConfig()
+	key := []byte(cfg.RandKey)
+	iv := []byte(comm.AttachIV)
+
+	info.Pwd, err = comm.AES_CBC_OpenBase64(info.Pwd, key, iv)
+	if err != nil {
+		return nil, err
+	}
+
+	info.Key, err = comm.AES_CBC_OpenBase64(info.Key, key, iv)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(info.Host) == 0 {
+		macInfo := &db.MacInfo{}
+		dbObj.Where("mac=?", mac).Find(macInfo)
+		info.Host = macInfo.IP
+	}
+
+	return info, nil
+}
+
+func actionName(action string) string {
+	switch action {
+	case ActionShutdown:
+		return "关机"
+	case ActionReboot:
+		return "重启"
+	case ActionSleep:
+		return "睡眠"
+	}
+
+	return action
+}
+
+// 对机器执行电源动作并记录日志
+func Run(mac string, action string) error {
+	if action != ActionShutdown && action != ActionReboot && action != ActionSleep {
+		return errors.New("未知的电源动作")
+	}
+
+	info, err := LoadPowerInfo(mac)
+	if err == nil {
+		err = info.Run(action)
+	}
+
+	if err != nil {
+		db.DBLog(actionName(action), "Mac：%s，失败：%s", mac, err.Error())
+		return err
+	}
+
+	db.DBLog(actionName(action), "Mac：%s，方式：%s，主机：%s", mac, info.TypeName(), info.Host)
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package power
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type SSHConfig struct {
+	Host    string
+	Port    int
+	User    string
+	Pwd     string
+	Key     string //PEM格式私钥
+	HostKey string //主机公钥指纹(SHA256:xxx)
+	Timeout time.Duration
+
+	//HostKey为空时首次连接调用，保存指纹后信任该主机；为nil时拒绝连接
+	OnHostKey func(fingerprint string) error
+}
+
+func (s *SSHConfig) clientConfig() (*ssh.ClientConfig, error) {
+	auths := []ssh.AuthMethod{}
+
+	if len(s.Key) != 0 {
+		signer, err := ssh.ParsePrivateKey([]byte(s.Key))
+		if err != nil {
+			//私钥有密码时使用pwd解密
+			if len(s.Pwd) == 0 {
+				return nil, err
+			}
+
+			signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(s.Key), []byte(s.Pwd))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		auths = append(auths, ssh.PublicKeys(signer))
+	}
+
+	if len(s.Pwd) != 0 {
+		auths = append(auths, ssh.Password(s.Pwd))
+		auths = append(auths, ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+			answers := make([]string, len(questions))
+			for i := range answers {
+				answers[i] = s.Pwd
+			}
+
+			return answers, nil
+		}))
+	}
+
+	if len(auths) == 0 {
+		return nil, errors.New("ssh password and key are empty")
+	}
+
+	hostKeyCallback := func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		fingerprint := ssh.FingerprintSHA256(key)
+
+		if len(s.HostKey) == 0 {
+			if s.OnHostKey == nil {
+				return errors.New("ssh host key not configured: " + fingerprint)
+			}
+
+			return s.OnHostKey(fingerprint)
+		}
+
+		if fingerprint != strings.TrimSpace(s.HostKey) {
+			return errors.New("ssh host key mismatch: " + fingerprint)
+		}
+
+		return nil
+	}
+
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+
+	return &ssh.ClientConfig{
+		User:            s.User,
+		Auth:            auths,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         timeout,
+	}, nil
+}
+
+// 通过SSH执行命令，返回输出
+func SSHExec(cfg SSHConfig, cmd string) (string, error) {
+	if len(cfg.Host) == 0 {
+		return "", errors.New("ssh host is empty")
+	}
+
+	if cfg.Port == 0 {
+		cfg.Port = 22
+	}
+
+	clientCfg, err := cfg.clientConfig()
+	if err != nil {
+		return "", err
+	}
+
+	client, err := ssh.Dial("tcp", net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)), clientCfg)
+	if err != nil {
+		return "", err
+	}
+
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+
+	defer session.Close()
+
+	//stdout和stderr由各自的协程写入，分开缓存，结束后再合并
+	stdout := bytes.NewBuffer(nil)
+	stderr := bytes.NewBuffer(nil)
+	session.Stdout = stdout
+	session.Stderr = stderr
+
+	err = session.Run(cmd)
+	out := stdout.String() + stderr.String()
+	if err != nil {
+		//关机命令执行后连接可能直接断开，不视为失败
+		var missing *ssh.ExitMissingError
+		if errors.As(err, &missing) {
+			return out, nil
+		}
+
+		if len(out) != 0 {
+			return out, errors.New(err.Error() + ", " + strings.TrimSpace(out))
+		}
+
+		return out, err
+	}
+
+	return out, nil
+}
@@ -0,0 +1,196 @@
+package power
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	testUser = "root"
+	testPwd  = "wakelan"
+)
+
+// 启动本地SSH服务，exec请求返回"ran: 命令"
+func startSSHServer(t *testing.T) (string, int, ssh.PublicKey) {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, pwd []byte) (*ssh.Permissions, error) {
+			if conn.User() == testUser && string(pwd) == testPwd {
+				return nil, nil
+			}
+
+			return nil, ssh.ErrNoAuth
+		},
+	}
+	cfg.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		listener.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go serveSSH(conn, cfg)
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, signer.PublicKey()
+}
+
+func serveSSH(conn net.Conn, cfg *ssh.ServerConfig) {
+	defer conn.Close()
+
+	_, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		return
+	}
+
+	go ssh.DiscardRequests(reqs)
+
+	for newChan := range chans {
+		if newChan.ChannelType() != "session" {
+			newChan.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+
+		channel, requests, err := newChan.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer channel.Close()
+
+			for req := range requests {
+				if req.Type != "exec" {
+					req.Reply(false, nil)
+					continue
+				}
+
+				payload := struct{ Command string }{}
+				ssh.Unmarshal(req.Payload, &payload)
+				req.Reply(true, nil)
+
+				channel.Write([]byte("ran: " + payload.Command))
+				channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+				return
+			}
+		}()
+	}
+}
+
+func TestSSHExecPinnedHostKey(t *testing.T) {
+	host, port, hostKey := startSSHServer(t)
+
+	out, err := SSHExec(SSHConfig{
+		Host:    host,
+		Port:    port,
+		User:    testUser,
+		Pwd:     testPwd,
+		HostKey: ssh.FingerprintSHA256(hostKey),
+		Timeout: 5 * time.Second,
+	}, "poweroff")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out != "ran: poweroff" {
+		t.Fatalf("unexpected output: %q", out)
+	}
+}
+
+func TestSSHExecHostKeyMismatch(t *testing.T) {
+	host, port, _ := startSSHServer(t)
+
+	_, err := SSHExec(SSHConfig{
+		Host:    host,
+		Port:    port,
+		User:    testUser,
+		Pwd:     testPwd,
+		HostKey: "SHA256:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA",
+		Timeout: 5 * time.Second,
+	}, "poweroff")
+
+	if err == nil || !strings.Contains(err.Error(), "mismatch") {
+		t.Fatalf("expected host key mismatch, got %v", err)
+	}
+}
+
+func TestSSHExecTrustOnFirstUse(t *testing.T) {
+	host, port, hostKey := startSSHServer(t)
+
+	cfg := SSHConfig{
+		Host:    host,
+		Port:    port,
+		User:    testUser,
+		Pwd:     testPwd,
+		Timeout: 5 * time.Second,
+	}
+
+	//未配置主机公钥且无记录回调时拒绝连接
+	_, err := SSHExec(cfg, "reboot")
+	if err == nil || !strings.Contains(err.Error(), "not configured") {
+		t.Fatalf("expected refusal without host key, got %v", err)
+	}
+
+	pinned := ""
+	cfg.OnHostKey = func(fingerprint string) error {
+		pinned = fingerprint
+		return nil
+	}
+
+	_, err = SSHExec(cfg, "reboot")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pinned != ssh.FingerprintSHA256(hostKey) {
+		t.Fatalf("pinned %q, want %q", pinned, ssh.FingerprintSHA256(hostKey))
+	}
+}
+
+func TestSSHExecWrongPassword(t *testing.T) {
+	host, port, hostKey := startSSHServer(t)
+
+	_, err := SSHExec(SSHConfig{
+		Host:    host,
+		Port:    port,
+		User:    testUser,
+		Pwd:     "wrong",
+		HostKey: ssh.FingerprintSHA256(hostKey),
+		Timeout: 5 * time.Second,
+	}, "poweroff")
+
+	if err == nil {
+		t.Fatal("expected authentication failure")
+	}
+}
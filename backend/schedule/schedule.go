@@ -0,0 +1,273 @@
+package schedule
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"wakelan/backend/comm"
+	"wakelan/backend/db"
+	"wakelan/backend/network"
+	"wakelan/backend/power"
+)
+
+// 任务动作
+const (
+	ActionWake     = "wake"
+	ActionShutdown = "shutdown"
+)
+
+// 任务目标类型
+const (
+	TargetDevice = "device"
+	TargetGroup  = "group"
+)
+
+type Scheduler struct {
+	lock    sync.Mutex
+	running map[uint]bool
+}
+
+// 检查任务配置
+func CheckJob(job *db.ScheduleJob) error {
+	if len(job.Name) == 0 {
+		return errors.New("任务名称不能为空")
+	}
+
+	_, err := ParseCron(job.Cron)
+	if err != nil {
+		return err
+	}
+
+	if job.Action != ActionWake && job.Action != ActionShutdown {
+		return errors.New("未知的任务动作")
+	}
+
+	if len(job.Target) == 0 {
+		return errors.New("目标不能为空")
+	}
+
+	switch job.TargetType {
+	case TargetDevice:
+		mac, err := net.ParseMAC(job.Target)
+		if err != nil {
+			return errors.New("MAC错误")
+		}
+
+		job.Target = mac.String()
+	case TargetGroup:
+		id, err := strconv.Atoi(job.Target)
+		if err != nil {
+			return errors.New("分组ID错误")
+		}
+
+		_, err = db.GetGroup(uint(id))
+		if err != nil {
+			return err
+		}
+	default:
+		return errors.New("未知的目标类型")
+	}
+
+	return nil
+}
+
+func (s *Scheduler) Start() {
+	go func() {
+		for {
+			//对齐到整分钟
+			now := time.Now()
+			time.Sleep(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+
+			s.check(time.Now())
+		}
+	}()
+}
+
+func (s *Scheduler) check(t time.Time) {
+	jobs := []db.ScheduleJob{}
+	dbObj := db.DBOperObj().GetDB()
+	dbObj.Where("enable=?", true).Find(&jobs)
+
+	for _, job := range jobs {
+		cron, err := ParseCron(job.Cron)
+		if err != nil {
+			continue
+		}
+
+		if !cron.Match(t) {
+			continue
+		}
+
+		go s.Run(job)
+	}
+}
+
+// 获取任务目标的MAC
+func (s *Scheduler) targets(job *db.ScheduleJob) ([]string, error) {
+	switch job.TargetType {
+	case TargetDevice:
+		return []string{job.Target}, nil
+	case TargetGroup:
+		id, err := strconv.Atoi(job.Target)
+		if err != nil {
+			return []string{}, err
+		}
+
+		group, err := db.GetGroup(uint(id))
+		if err != nil {
+			return []string{}, err
+		}
+
+		macs := []string{}
+		for _, m := range group.Members {
+			macs = append(macs, m.Mac)
+		}
+
+		return macs, nil
+	}
+
+	return []string{}, errors.New("未知的目标类型")
+}
+
+// 分组唤醒按分组配置的间隔依次执行，执行过程可在分组唤醒页面查看
+func (s *Scheduler) wakeGroup(job *db.ScheduleJob) error {
+	id, err := strconv.Atoi(job.Target)
+	if err != nil {
+		return err
+	}
+
+	info, err := network.GroupWakeMG().Wake(uint(id), -1)
+	if err != nil {
+		return err
+	}
+
+	errs := []string{}
+	for _, m := range info.Members {
+		if m.State == network.GroupMemberError {
+			errs = append(errs, m.Mac+"："+m.Err)
+		}
+	}
+
+	if len(errs) != 0 {
+		return errors.New(strings.Join(errs, "；"))
+	}
+
+	return nil
+}
+
+// 标记任务开始执行，同一任务不会并行执行
+func (s *Scheduler) begin(id uint) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.running[id] {
+		return errors.New("任务正在执行")
+	}
+
+	s.running[id] = true
+
+	return nil
+}
+
+func (s *Scheduler) end(id uint) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.running, id)
+}
+
+// 执行任务，同一任务不会并行执行
+func (s *Scheduler) Run(job db.ScheduleJob) error {
+	err := s.begin(job.ID)
+	if err != nil {
+		return err
+	}
+
+	defer s.end(job.ID)
+
+	return s.run(job)
+}
+
+// 后台执行任务，立即返回，执行结果记录在任务的最近执行结果中
+func (s *Scheduler) RunAsync(job db.ScheduleJob) error {
+	err := s.begin(job.ID)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		defer s.end(job.ID)
+		s.run(job)
+	}()
+
+	return nil
+}
+
+func (s *Scheduler) run(job db.ScheduleJob) error {
+	result := "成功"
+
+	err := func() error {
+		if job.Action == ActionWake && job.TargetType == TargetGroup {
+			return s.wakeGroup(&job)
+		}
+
+		macs, err := s.targets(&job)
+		if err != nil {
+			return err
+		}
+
+		errs := []string{}
+
+		for _, mac := range macs {
+			var err error
+			switch job.Action {
+			case ActionWake:
+				err = network.NetProtoObj().WakeDevice(mac)
+			case ActionShutdown:
+				err = power.Run(mac, power.ActionShutdown)
+			default:
+				err = errors.New("未知的任务动作")
+			}
+
+			if err != nil {
+				errs = append(errs, mac+"："+err.Error())
+			}
+		}
+
+		if len(errs) != 0 {
+			return errors.New(strings.Join(errs, "；"))
+		}
+
+		return nil
+	}()
+
+	if err != nil {
+		result = "失败，" + err.Error()
+	}
+
+	job.LastRun = time.Now().Format(comm.TimeFormat)
+	job.LastResult = result
+
+	dbObj := db.DBOperObj().GetDB()
+	dbObj.Model(&job).Select("last_run", "last_result").Updates(&job)
+
+	db.DBLog("定时任务", "任务：%s，动作：%s，目标：%s，结果：%s", job.Name, job.Action, job.Target, result)
+
+	return err
+}
+
+var schedulerOnce sync.Once
+var schedulerObj *Scheduler
+
+func SchedulerObj() *Scheduler {
+	schedulerOnce.Do(func() {
+		schedulerObj = &Scheduler{
+			running: make(map[uint]bool),
+		}
+	})
+
+	return schedulerObj
+}
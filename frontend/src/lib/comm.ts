@@ -209,6 +209,14 @@ export function DeleteCookie(key: string) {
     document.cookie = `${key}=; expires=Thu, 01 Jan 1970 00:00:00 UTC; path=/api;`
 }
 
+//退出登录，服务端删除会话并清除cookie
+export function Logout() {
+    fetch('/api/user/logout', { method: 'POST' }).finally(() => {
+        DeleteCookie('token')
+        router.push('/login')
+    })
+}
+
 //兼容复制到剪贴板
 function copyToClipboard(text: string) {
     let textarea = document.createElement("textarea")
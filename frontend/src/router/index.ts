@@ -24,6 +24,11 @@ const router = createRouter({
       name: 'config',
       component: () => import('../views/Config.vue')
     },
+    {
+      path: '/account',
+      name: 'account',
+      component: () => import('../views/Account.vue')
+    },
     {
       path: '/login',
       name: 'login',
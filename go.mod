@@ -11,6 +11,8 @@ require (
 	github.com/pquerna/otp v1.4.0
 	github.com/shirou/gopsutil/v3 v3.24.2
 	github.com/wxpusher/wxpusher-sdk-go v1.0.3
+	golang.org/x/crypto v0.14.0
+	golang.org/x/net v0.17.0
 	gorm.io/driver/sqlite v1.5.4
 	gorm.io/gorm v1.25.5
 )
@@ -64,8 +66,6 @@ require (
 	go.opentelemetry.io/otel/sdk v1.22.0 // indirect
 	go.opentelemetry.io/otel/trace v1.22.0 // indirect
 	golang.org/x/arch v0.3.0 // indirect
-	golang.org/x/crypto v0.14.0 // indirect
-	golang.org/x/net v0.17.0 // indirect
 	golang.org/x/sys v0.17.0 // indirect
 	golang.org/x/text v0.13.0 // indirect
 	golang.org/x/time v0.5.0 // indirect